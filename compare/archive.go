@@ -0,0 +1,310 @@
+package compare
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// archiveEntry is a single file or directory inside an archive.
+type archiveEntry struct {
+	dir  bool
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+// archive is an archive opened for comparison, with its entries keyed by their slash separated paths. Parent
+// directories which are not stored in the archive are added as entries as well.
+type archive struct {
+	path    string
+	entries map[string]archiveEntry
+	close   func() error
+}
+
+// add adds an entry to the archive along with any missing parent directories.
+func (a *archive) add(name string, e archiveEntry) {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return
+	}
+	a.entries[name] = e
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := a.entries[dir]; ok {
+			break
+		}
+		a.entries[dir] = archiveEntry{dir: true}
+	}
+}
+
+// isZip returns whether a file is a zip archive, either by its extension or by its leading magic bytes.
+func (c *comparer) isZip(side int, file string) bool {
+	if strings.EqualFold(path.Ext(file), ".zip") {
+		return true
+	}
+
+	f, err := c.open(side, file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return bytes.Equal(magic, []byte("PK\x03\x04")) || bytes.Equal(magic, []byte("PK\x05\x06"))
+}
+
+// isTar returns whether a file is a tar archive, possibly gzip compressed, either by its extension or, if it is not
+// compressed, by the magic bytes of its first header.
+func (c *comparer) isTar(side int, file string) bool {
+	lower := strings.ToLower(file)
+	if strings.HasSuffix(lower, ".tar") || strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz") {
+		return true
+	}
+
+	f, err := c.open(side, file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 262)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	return bytes.Equal(header[257:], []byte("ustar"))
+}
+
+// archiveOpener returns the function opening a file as an archive, or nil if it is not an archive.
+func (c *comparer) archiveOpener(side int, file string) func(int, string) (*archive, error) {
+	if c.isZip(side, file) {
+		return c.openZip
+	}
+	if c.isTar(side, file) {
+		return c.openTar
+	}
+	return nil
+}
+
+// openZip opens a zip archive.
+func (c *comparer) openZip(side int, file string) (*archive, error) {
+	f, err := c.open(side, file)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%v: %w", file, err)
+	}
+
+	a := &archive{path: file, entries: make(map[string]archiveEntry), close: f.Close}
+	for _, f := range r.File {
+		a.add(f.Name, archiveEntry{dir: f.FileInfo().IsDir(), size: int64(f.UncompressedSize64), open: f.Open})
+	}
+	return a, nil
+}
+
+// openTar opens a tar archive, decompressing it first if it is gzip compressed. Tar archives can only be read in order,
+// so the contents of all regular files are read into memory. Other kinds of members, such as symlinks, are skipped.
+func (c *comparer) openTar(side int, file string) (*archive, error) {
+	f, err := c.open(side, file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Gzip compression is detected by its magic bytes, regardless of the extension.
+	br := bufio.NewReaderSize(f, BUFFER_SIZE)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	a := &archive{path: file, entries: make(map[string]archiveEntry), close: func() error { return nil }}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", file, err)
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			a.add(h.Name, archiveEntry{dir: true})
+		case tar.TypeReg:
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", file, err)
+			}
+			a.add(h.Name, archiveEntry{size: int64(len(data)), open: func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(data)), nil
+			}})
+		}
+	}
+	return a, nil
+}
+
+// diffArchives compares two archives entry by entry, as if they were directories, and reports which entries are
+// different. Entries are reported with the path of the archive followed by their path inside it. Items only present
+// in one archive are reported once for their topmost directory. Should be called via a goroutine.
+func (c *comparer) diffArchives(file1 string, file2 string) {
+	defer c.wg.Done()
+	if c.stopped() {
+		return
+	}
+	if c.opts.OnStart != nil {
+		c.opts.OnStart(file1, file2)
+	}
+
+	a1, err := c.archiveOpener(1, file1)(1, file1)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	defer a1.close()
+	a2, err := c.archiveOpener(2, file2)(2, file2)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	defer a2.close()
+
+	// Compare entries in a stable order, skipping excluded ones along with everything below skipped directories, as
+	// when walking a directory. Only the skipped entries themselves are reported when planning.
+	names := func(side int, a *archive) []string {
+		var names []string
+		for name, e := range a.entries {
+			if c.skippedAbove(name) {
+				continue
+			}
+			if !c.skipped(name, e.dir) {
+				names = append(names, name)
+			} else if c.opts.Plan {
+				c.report(Difference{Type: SKIPPED, Path1: c.join(side, a.path, name)})
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	for _, name := range names(1, a1) {
+		if c.stopped() {
+			return
+		}
+
+		e1 := a1.entries[name]
+		e2, ok := a2.entries[name]
+		path1 := c.join(1, file1, name)
+		path2 := c.join(2, file2, name)
+		if !ok {
+			if !covered(name, a2) {
+				c.report(Difference{Type: ONLY_IN, Dir: c.join(1, file1, path.Dir(name)), Name: path.Base(name), Side: 1})
+			}
+			continue
+		}
+
+		if e1.dir && e2.dir {
+			continue
+		} else if e1.dir {
+			c.report(Difference{Type: TYPE_MISMATCH, Path1: path1, Path2: path2, Kind1: "directory", Kind2: "file"})
+		} else if e2.dir {
+			c.report(Difference{Type: TYPE_MISMATCH, Path1: path1, Path2: path2, Kind1: "file", Kind2: "directory"})
+		} else {
+			c.diffArchiveEntries(path1, e1, path2, e2)
+		}
+	}
+
+	for _, name := range names(2, a2) {
+		if _, ok := a1.entries[name]; !ok && !covered(name, a1) {
+			c.report(Difference{Type: ONLY_IN, Dir: c.join(2, file2, path.Dir(name)), Name: path.Base(name), Side: 2})
+		}
+	}
+}
+
+// covered returns whether an entry is already accounted for by its parent, which happens if the parent directory is
+// not a directory in the other archive and so has been reported itself.
+func covered(name string, other *archive) bool {
+	dir := path.Dir(name)
+	if dir == "." {
+		return false
+	}
+	e, ok := other.entries[dir]
+	return !ok || !e.dir
+}
+
+// diffArchiveEntries compares two files inside archives byte for byte and reports whether they are different.
+func (c *comparer) diffArchiveEntries(path1 string, e1 archiveEntry, path2 string, e2 archiveEntry) {
+	size1, size2 := e1.size, e2.size
+	if c.opts.Plan {
+		c.report(Difference{Type: PLANNED, Path1: path1, Path2: path2, Size1: &size1, Size2: &size2})
+		return
+	}
+	if size1 != size2 && !c.text() {
+		c.report(Difference{Type: FILES_DIFFER, Path1: path1, Path2: path2, Size1: &size1, Size2: &size2, BySize: true})
+		return
+	}
+
+	r1, err := e1.open()
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	defer r1.Close()
+	r2, err := e2.open()
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	defer r2.Close()
+
+	if !c.acquire() {
+		return
+	}
+	eq, offset, err := c.cmpContents(r1, r2)
+	c.compared()
+	c.release()
+	if err != nil {
+		c.fail(err)
+		return
+	}
+
+	if !eq {
+		d := Difference{Type: FILES_DIFFER, Path1: path1, Path2: path2, Size1: &size1, Size2: &size2}
+		if offset >= 0 {
+			d.Offset = &offset
+		}
+		c.report(d)
+	} else if c.opts.ReportIdentical {
+		c.report(Difference{Type: IDENTICAL, Path1: path1, Path2: path2})
+	}
+}
+
+// skippedAbove returns whether any directory above an entry of an archive is skipped.
+func (c *comparer) skippedAbove(name string) bool {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if c.skipped(dir, true) {
+			return true
+		}
+	}
+	return false
+}
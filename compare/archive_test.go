@@ -0,0 +1,49 @@
+package compare
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeZip creates a zip archive holding files by their slash separated paths.
+func writeZip(t *testing.T, name string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for file, data := range files {
+		fw, err := w.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestArchiveExcludeDir checks that entries below a directory of an archive matching an exclude pattern are skipped,
+// as they are when walking a directory.
+func TestArchiveExcludeDir(t *testing.T) {
+	dir := t.TempDir()
+	zip1, zip2 := filepath.Join(dir, "a.zip"), filepath.Join(dir, "b.zip")
+	writeZip(t, zip1, map[string]string{"dir/file": "x", "dir/sub/file": "x", "only1": "", "file": "x"})
+	writeZip(t, zip2, map[string]string{"dir/file": "y", "dir/sub/file": "y", "file": "y"})
+
+	diffs, err := DiffFiles(context.Background(), zip1, zip2, Options{Exclude: []string{"dir", "only1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].Type != FILES_DIFFER || diffs[0].Path1 != filepath.Join(zip1, "file") {
+		t.Errorf("got %+v, want only file to differ", diffs)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package compare
+
+import "os"
+
+// Whether Windows file attributes can be compared on this platform.
+const attributesSupported = false
+
+// attributes is not supported on this platform, so the attributes are never known.
+func attributes(stat os.FileInfo) (string, bool) {
+	return "", false
+}
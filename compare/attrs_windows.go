@@ -0,0 +1,39 @@
+//go:build windows
+
+package compare
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Whether Windows file attributes can be compared on this platform.
+const attributesSupported = true
+
+// attributes returns the readonly, hidden and system attributes of a file as a comma separated list, or none if it has
+// none of them. Other attributes, such as archive which is set whenever a file is written, are left out. ok is false if
+// they are not known.
+func attributes(stat os.FileInfo) (string, bool) {
+	d, ok := stat.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return "", false
+	}
+	var names []string
+	for _, a := range []struct {
+		bit  uint32
+		name string
+	}{
+		{syscall.FILE_ATTRIBUTE_READONLY, "readonly"},
+		{syscall.FILE_ATTRIBUTE_HIDDEN, "hidden"},
+		{syscall.FILE_ATTRIBUTE_SYSTEM, "system"},
+	} {
+		if d.FileAttributes&a.bit != 0 {
+			names = append(names, a.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none", true
+	}
+	return strings.Join(names, ","), true
+}
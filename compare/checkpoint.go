@@ -0,0 +1,145 @@
+package compare
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// checkpointEntry is a line of a checkpoint file, a pair of files which has been compared along with the differences
+// found between them.
+type checkpointEntry struct {
+	Path1 string       `json:"path1"`
+	Path2 string       `json:"path2"`
+	Diffs []Difference `json:"diffs"`
+}
+
+// checkpoint records the pairs of files compared so far to a file as they are done, so that an interrupted comparison
+// can be resumed without comparing them again. Pairs are identified by their paths, so the order in which they are
+// compared does not matter.
+type checkpoint struct {
+	mu   sync.Mutex
+	file *os.File
+	// Differences of the pairs compared by earlier runs.
+	done map[[2]string][]Difference
+	// Pairs being compared, by the paths of both of their files, collecting the differences reported for them.
+	active map[string]*checkpointEntry
+}
+
+// loadCheckpoint loads the pairs recorded in a checkpoint file, if it exists, and opens it to record more. A last line
+// which is cut off, as happens if the run writing it was killed, is ignored.
+func loadCheckpoint(name string) (*checkpoint, error) {
+	data, err := os.ReadFile(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	cp := &checkpoint{done: make(map[[2]string][]Difference), active: make(map[string]*checkpointEntry)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		var e checkpointEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err == nil {
+			cp.done[[2]string{e.Path1, e.Path2}] = e.Diffs
+		}
+	}
+
+	if cp.file, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err != nil {
+		return nil, err
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		if _, err := cp.file.Write([]byte("\n")); err != nil {
+			cp.file.Close()
+			return nil, err
+		}
+	}
+	return cp, nil
+}
+
+// resume returns the differences of a pair of files compared by an earlier run. ok is false if the pair has not been
+// compared yet, in which case it is marked as being compared so that the differences reported for it are collected.
+func (cp *checkpoint) resume(file1 string, file2 string) (diffs []Difference, ok bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if diffs, ok := cp.done[[2]string{file1, file2}]; ok {
+		return diffs, true
+	}
+	e := &checkpointEntry{Path1: file1, Path2: file2, Diffs: []Difference{}}
+	cp.active[file1], cp.active[file2] = e, e
+	return nil, false
+}
+
+// add collects a difference reported for a pair of files being compared. Differences of anything else are ignored.
+func (cp *checkpoint) add(d Difference) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if e := cp.active[d.Path1]; e != nil {
+		e.Diffs = append(e.Diffs, d)
+	}
+}
+
+// finish records a pair of files as compared, along with the differences collected for it, unless complete is false
+// or any of them is incomplete. Such pairs are compared again when resuming.
+func (cp *checkpoint) finish(file1 string, file2 string, complete bool) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	e := cp.active[file1]
+	delete(cp.active, file1)
+	delete(cp.active, file2)
+	if e == nil || !complete {
+		return nil
+	}
+	for _, d := range e.Diffs {
+		if d.Incomplete() {
+			return nil
+		}
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = cp.file.Write(append(data, '\n'))
+	return err
+}
+
+// close closes the checkpoint file.
+func (cp *checkpoint) close() error {
+	return cp.file.Close()
+}
+
+// openCheckpoint opens the checkpoint file, if asked for. Checkpoints are not used when planning.
+func (c *comparer) openCheckpoint() error {
+	if c.opts.Checkpoint == "" || c.opts.Plan {
+		return nil
+	}
+	cp, err := loadCheckpoint(c.opts.Checkpoint)
+	if err != nil {
+		return fmt.Errorf("cannot open checkpoint: %w", err)
+	}
+	c.checkpoint = cp
+	return nil
+}
+
+// resumed returns whether a pair of files was compared by an earlier run, in which case the differences found then are
+// reported again. Otherwise the pair is marked as being compared.
+func (c *comparer) resumed(file1 string, file2 string) bool {
+	diffs, ok := c.checkpoint.resume(file1, file2)
+	for _, d := range diffs {
+		c.report(d)
+	}
+	return ok
+}
+
+// checkpointed records a pair of files as compared, if it was compared completely. An error writing the checkpoint
+// stops the comparison.
+func (c *comparer) checkpointed(file1 string, file2 string) {
+	if err := c.checkpoint.finish(file1, file2, !c.stopped()); err != nil {
+		c.fail(err)
+	}
+}
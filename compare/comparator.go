@@ -0,0 +1,113 @@
+package compare
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// Names of the comparators files can be compared with, as chosen by extension with Comparators.
+const (
+	COMPARE_BYTES = "bytes"
+	COMPARE_JSON  = "json"
+	COMPARE_YAML  = "yaml"
+	COMPARE_CSV   = "csv"
+	COMPARE_LINES = "lines"
+	// Files compared by their decompressed contents with Decompress. It cannot be chosen with Comparators.
+	compareDecompress = "decompress"
+)
+
+// comparator compares two files in a way suited to their format. ok is false if either file turns out not to be in
+// that format, in which case the comparator has reported a warning and the files are compared byte for byte instead.
+// Otherwise diff is nil if the files are equal, or holds the fields of the difference which describe how they differ,
+// such as Offset or Paths.
+type comparator func(c *comparer, file1 string, file2 string) (diff *Difference, ok bool, err error)
+
+// comparators holds every comparator by name. Comparing byte for byte, which also covers the text comparisons, is the
+// default for files no other comparator applies to.
+var comparators = map[string]comparator{
+	COMPARE_BYTES:     cmpBytes,
+	compareDecompress: cmpDecompressed,
+	COMPARE_JSON: func(c *comparer, file1 string, file2 string) (*Difference, bool, error) {
+		paths, ok, err := c.cmpSemantic(file1, file2, decodeJSON, INVALID_JSON)
+		return pathsDiff(paths), ok, err
+	},
+	COMPARE_YAML: func(c *comparer, file1 string, file2 string) (*Difference, bool, error) {
+		paths, ok, err := c.cmpSemantic(file1, file2, decodeYAML, INVALID_YAML)
+		return pathsDiff(paths), ok, err
+	},
+	COMPARE_CSV: func(c *comparer, file1 string, file2 string) (*Difference, bool, error) {
+		diff, ok, err := c.cmpCSV(file1, file2)
+		if diff == nil {
+			return nil, ok, err
+		}
+		return &Difference{CSV: diff}, ok, err
+	},
+	COMPARE_LINES: func(c *comparer, file1 string, file2 string) (*Difference, bool, error) {
+		diff, ok, err := c.cmpLines(file1, file2)
+		if diff == nil {
+			return nil, ok, err
+		}
+		return &Difference{Lines: diff}, ok, err
+	},
+}
+
+// cmpBytes compares two files byte for byte as cmpFiles does, including the offset of their first differing byte if
+// known. It applies to files of any format.
+func cmpBytes(c *comparer, file1 string, file2 string) (*Difference, bool, error) {
+	d, err := c.cmpFiles(file1, file2)
+	return d, true, err
+}
+
+// pathsDiff returns the difference of files compared by the data they hold whose values at the given paths differ, or
+// nil if none do.
+func pathsDiff(paths []string) *Difference {
+	if len(paths) == 0 {
+		return nil
+	}
+	return &Difference{Paths: paths}
+}
+
+// comparatorName returns the name of the comparator two files are compared with. Files which would be compared with
+// different comparators by their extensions are compared byte for byte, unless either is compressed and Decompress is
+// set.
+func (c *comparer) comparatorName(file1 string, file2 string) string {
+	if c.opts.Decompress && (isCompressed(file1) || isCompressed(file2)) {
+		return compareDecompress
+	}
+	name1, name2 := c.comparatorFor(file1), c.comparatorFor(file2)
+	if name1 != name2 {
+		return COMPARE_BYTES
+	}
+	return name1
+}
+
+// comparatorFor returns the name of the comparator a file is compared with by its extension. Comparators chosen for
+// an extension with Comparators take precedence over those enabled by JSONSemantic, YAMLSemantic and CSV, while
+// LinesSet applies to files of any other extension. If several keys of Comparators match, such as log and .LOG, the
+// first of them in sorted order wins, so that the choice does not change between runs.
+func (c *comparer) comparatorFor(file string) string {
+	if ext := path.Ext(file); ext != "" {
+		exts := make([]string, 0, len(c.opts.Comparators))
+		for e := range c.opts.Comparators {
+			exts = append(exts, e)
+		}
+		sort.Strings(exts)
+		for _, e := range exts {
+			if strings.EqualFold(strings.TrimPrefix(e, "."), ext[1:]) {
+				return c.opts.Comparators[e]
+			}
+		}
+	}
+	switch {
+	case c.opts.JSONSemantic && isJSON(file):
+		return COMPARE_JSON
+	case c.opts.YAMLSemantic && isYAML(file):
+		return COMPARE_YAML
+	case c.opts.CSV && isCSV(file):
+		return COMPARE_CSV
+	case c.opts.LinesSet:
+		return COMPARE_LINES
+	}
+	return COMPARE_BYTES
+}
@@ -0,0 +1,1656 @@
+/*
+Package compare checks files and directories for differences. Files are compared byte for byte while directories are
+compared by their contents, optionally recursing into common subdirectories.
+
+Comparisons are parallelized, hence the order of reported differences is not specified and may vary across runs.
+*/
+package compare
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of bytes to compare at once.
+const CHUNK_SIZE = 4 * 1024
+
+// Default size of the buffered readers used to read files.
+const BUFFER_SIZE = 64 * 1024
+
+// Number of bytes of memory mapped files to compare at once, between checks for whether work has been stopped.
+const MMAP_CHUNK_SIZE = 1024 * 1024
+
+// Types of differences which can be reported.
+const (
+	FILES_DIFFER      = "files_differ"
+	ONLY_IN           = "only_in"
+	TYPE_MISMATCH     = "type_mismatch"
+	COMMON_SUBDIR     = "common_subdir"
+	SYMLINK_LOOP      = "symlink_loop"
+	MODE_DIFFER       = "mode_differ"
+	RENAMED           = "renamed"
+	IDENTICAL         = "identical"
+	BROKEN_SYMLINK    = "broken_symlink"
+	LINKS_DIFFER      = "links_differ"
+	DEVICES_DIFFER    = "devices_differ"
+	TIME_DIFFER       = "time_differ"
+	PERMISSION_DENIED = "permission_denied"
+	READ_ERROR        = "read_error"
+	OWNER_DIFFER      = "owner_differ"
+	XATTR_DIFFER      = "xattr_differ"
+	ATTRIBUTES_DIFFER = "attributes_differ"
+	EMPTY_DIR         = "empty_dir"
+	PLANNED           = "planned"
+	SKIPPED           = "skipped"
+	MOUNT_POINT       = "mount_point"
+	INVALID_JSON      = "invalid_json"
+	INVALID_YAML      = "invalid_yaml"
+	INVALID_IMAGE     = "invalid_image"
+	INVALID_CSV       = "invalid_csv"
+	BINARY_FILE       = "binary_file"
+	CHANGED           = "changed"
+	CHANGED_BOTH      = "changed_both"
+	CONFLICT          = "conflict"
+	TOO_LARGE         = "too_large"
+	TOO_SMALL         = "too_small"
+	DUPLICATES        = "duplicates"
+	ERROR             = "error"
+)
+
+// Options controls how directories are compared.
+type Options struct {
+	// Recursively compare common subdirectories.
+	Recursive bool
+	// Maximum depth of subdirectories to recurse into, zero meaning unlimited. Common subdirectories deeper than this
+	// are reported instead of being compared.
+	MaxDepth int
+	// Stop at the first difference found. Only that difference is returned.
+	Brief bool
+	// Stop after Limit differences are found, zero meaning unlimited. Only those differences are returned, along with
+	// items reported before the last of them which are not differences.
+	Limit int
+	// Maximum number of files or directories being read at once. Defaults to the number of CPUs if not positive.
+	Jobs int
+	// Compare one item at a time in the order of the entries of each directory, directories depth first, within the
+	// single goroutine doing the comparison instead of one per file and directory. Differences are then always reported
+	// in the same order, which is otherwise only the case for the differences themselves. Jobs and ThreadsPerFile are
+	// ignored.
+	Sequential bool
+	// Size in bytes of the buffers used to read files. Defaults to BUFFER_SIZE if not positive.
+	BufferSize int
+	// Compare files by memory mapping them instead of reading them, where supported. Files which cannot be mapped are
+	// read as usual.
+	Mmap bool
+	// Number of ranges to split files of at least RANGES_MIN_SIZE bytes into, each compared in parallel by its own
+	// reader. Values below 2 compare files with a single reader. These readers come on top of Jobs, and files which
+	// are memory mapped are not split.
+	ThreadsPerFile int
+	// Patterns, as accepted by path.Match, of entries to skip. A pattern is matched against both the entry name and its
+	// slash separated path relative to the compared directories. Excluded entries are neither compared nor reported.
+	Exclude []string
+	// Patterns, matched like Exclude, of files to compare. If set, only files matching one of them are compared and
+	// other files are neither compared nor reported. Directories are still compared so that matching files inside them
+	// are found. Exclude takes precedence over Include.
+	Include []string
+	// Also leave out entries ignored by the .gitignore files of the compared directories and their subdirectories, as
+	// git does, along with .git directories. Each side is filtered by its own .gitignore files.
+	Gitignore bool
+	// Leave out hidden entries, those whose names start with a dot, along with everything inside hidden directories.
+	// They are left out like excluded entries, so Include does not bring them back, while the paths compared are never
+	// left out themselves.
+	NoHidden bool
+	// Also compare permission bits of files with equal contents.
+	Mode bool
+	// Also compare modification times of files with equal contents. Times within TimeTolerance of each other are
+	// considered equal, to allow for filesystems with coarse timestamps.
+	Time          bool
+	TimeTolerance time.Duration
+	// Also compare the owning user and group ids of files with equal contents. Only supported on Unix.
+	Owner bool
+	// Also compare the extended attributes of local files with equal contents. Only supported on Linux and macOS.
+	// Files on filesystems without extended attributes are not compared by them.
+	Xattr bool
+	// Also compare the readonly, hidden and system attributes of local files with equal contents. Only supported on
+	// Windows.
+	Attributes bool
+	// Consider files equal if their sizes are equal, without reading their contents.
+	SizeOnly bool
+	// Compare files only by their metadata, without ever opening them: their sizes as with SizeOnly, along with their
+	// permission bits, modification times and owners if Mode, Time and Owner are set. Options which need the contents
+	// of files, such as Hash, the text and semantic comparisons, Unified, Patch and DetectRenames, are ignored, as is
+	// MaxSize, and files are never compared as archives.
+	StatOnly bool
+	// Compare archives as files, byte for byte or as asked for, instead of entry by entry.
+	NoArchives bool
+	// Compare text files ignoring a single newline at their end. Files with a NUL byte within their first SNIFF_SIZE
+	// bytes are considered binary and still compared byte for byte. Text files which differ have no Offset, as the
+	// offset of their first difference is only known in the normalized text. This disables SizeOnly, Hash and Mmap.
+	IgnoreTrailingNewline bool
+	// Compare text files treating CRLF and LF line endings as equal. Binary files, and the offsets of text files which
+	// differ, are handled as for IgnoreTrailingNewline.
+	IgnoreLineEndings bool
+	// Compare text files ignoring whitespace at the start and end of lines and treating every other run of whitespace
+	// as a single space. Whitespace is spaces, tabs, carriage returns, vertical tabs and form feeds, but not newlines.
+	// Binary files, and the offsets of text files which differ, are handled as for IgnoreTrailingNewline.
+	IgnoreWhitespace bool
+	// Compare text files ignoring lines which are empty or only hold whitespace. Binary files, and the offsets of text
+	// files which differ, are handled as for IgnoreTrailingNewline.
+	IgnoreBlankLines bool
+	// Compare text files ignoring the case of letters, as if they were all lower case. Binary files, and the offsets of
+	// text files which differ, are handled as for IgnoreTrailingNewline.
+	IgnoreContentCase bool
+	// Compare files by their digests using one of SHA256, MD5 or CRC32 instead of byte for byte.
+	Hash string
+	// Pair up files only present in one directory with files of identical contents only present in the other, and
+	// report them as renamed. The files are hashed using Hash, or SHA256 if not set.
+	DetectRenames bool
+	// Match entry names case insensitively. Entries whose names only differ in case within the same directory are
+	// matched by their exact names instead.
+	IgnoreCase bool
+	// Treat a compared path which does not exist as empty, as long as the other one exists, so that every entry of the
+	// other path is reported as only in it, or the other path itself if it is a file.
+	AllowMissing bool
+	// If set, updated with counts of reported differences as they are found.
+	Stats *Stats
+	// If set, updated with counts of files compared and bytes read as the comparison progresses.
+	Progress *Progress
+	// If set, updated with whether the paths differ and whether everything could be compared as differences are found.
+	Result *Result
+	// If set, called with the paths of each pair of files, archives included, before they are compared, and with each
+	// difference reported, including those which are not differences such as warnings, before it is sent. They are
+	// called from the goroutines doing the comparison, so they may run concurrently and must be safe for that, and they
+	// hold up the comparison of the pair or directory they are called for until they return.
+	OnStart  func(file1 string, file2 string)
+	OnResult func(d Difference)
+	// Only plan the comparison. Directories are walked as usual, but instead of comparing files, pairs of files which
+	// would be compared are reported as PLANNED and entries left out by Exclude or Include as SKIPPED. Files are not
+	// opened, and renames are not detected.
+	Plan bool
+	// Also report files which are identical.
+	ReportIdentical bool
+	// Path of a checkpoint file recording each pair of files once compared, along with the differences found between
+	// them, so that an interrupted comparison can be resumed by running it again with the same paths. Pairs found in
+	// the file are not compared again and their differences are reported as recorded. Pairs with differences which are
+	// Incomplete are compared again. Not used when planning, and not supported by three-way comparisons.
+	Checkpoint string
+	// Include a unified diff with Context lines of context in the difference of text files which differ.
+	Unified bool
+	Context int
+	// Produce a patch, which is like Unified except that the files in each diff are named by their paths relative to the
+	// compared paths, prefixed by a/ and b/, and that items only present on one side include a diff adding or deleting
+	// every text file in them against /dev/null. Binary files are left out, and entries of archives have no diffs.
+	Patch bool
+	// Estimate how similar files which differ are, as a percentage. See the Similarity field of Difference.
+	Similarity bool
+	// Follow symlinks inside the compared directories, so that symlinks to directories are recursed into and symlinks
+	// to files are compared by their contents. By default symlinks are compared by their targets, and a symlink is never
+	// equal to a file or directory.
+	FollowSymlinks bool
+	// Follow symlinks inside only the first or second path, as FollowSymlinks does inside both, so that a tree using
+	// symlinks to share files can be compared against one holding copies of them. Symlinks on the other side are
+	// compared by their targets, and a symlink which is not followed is never equal to a followed one.
+	FollowSymlinks1 bool
+	FollowSymlinks2 bool
+	// Filesystems to read the first and second paths from instead of the local filesystem, such as an fstest.MapFS.
+	// Paths on such a filesystem must be valid as per fs.ValidPath. Symlinks are not supported and Mmap does not
+	// apply.
+	FS1 fs.FS
+	FS2 fs.FS
+	// Do not recurse into subdirectories on a different filesystem than the compared directories, such as mount
+	// points, which are reported as MOUNT_POINT instead. Only supported on Unix.
+	SameFilesystem bool
+	// Compare files with a .json extension by the data they hold, so that formatting and the order of object keys do
+	// not matter. Files which are not valid JSON are reported as INVALID_JSON and compared byte for byte instead.
+	JSONSemantic bool
+	// Compare files with a .yaml or .yml extension by the data they hold, document by document, like JSONSemantic.
+	// Files which are not valid YAML are reported as INVALID_YAML and compared byte for byte instead.
+	YAMLSemantic bool
+	// Compare files with a .csv extension by the data they hold, so that the order of columns and rows does not matter.
+	// The first record of each file is its header. Rows are matched by the values of the CSVKeys columns, or by all their
+	// values if none are given. See the CSV field of Difference. Files which cannot be parsed or lack a key column are
+	// reported as INVALID_CSV and compared byte for byte instead.
+	CSV     bool
+	CSVKeys []string
+	// Compare text files as sets of lines, like comm, so that the order of lines and how often they appear do not
+	// matter. See the Lines field of Difference. Only the digests of the lines of the first file are kept in memory.
+	// Binary files are reported as BINARY_FILE and compared byte for byte instead. Files compared by their data
+	// otherwise are not compared this way.
+	LinesSet bool
+	// Compare files with a .gz, .bz2 or .zst extension by their decompressed contents, against the other file
+	// decompressed as well if it is compressed too, taking precedence over the other comparisons. Within directories
+	// such files are paired with the file of their name without the extension, so that config.json.gz on one side is
+	// compared against config.json on the other, unless the directory holds both. Differences found this way are marked
+	// as Decompressed.
+	Decompress bool
+	// Comparators to compare files with by their extensions, such as "log" or ".log", overriding those chosen by the
+	// options above. One of COMPARE_BYTES, COMPARE_JSON, COMPARE_YAML, COMPARE_CSV or COMPARE_LINES. Files of two
+	// extensions with different comparators are compared byte for byte. Keys matching the same extension are tried in
+	// sorted order.
+	Comparators map[string]string
+	// Include a hex dump of the regions around the differences in the difference of binary files which differ.
+	Hex bool
+	// Decode PNG and JPEG files which differ, by their extension, and compare them pixel by pixel. See the Image field
+	// of Difference. Files which cannot be decoded are reported as INVALID_IMAGE.
+	Image bool
+	// Once two directories have been compared, hash every file below both of them, like Manifest, and report each group
+	// of files with identical contents as DUPLICATES, wherever they are. Groups made of a single file in each directory
+	// at the same relative path, which has not changed, are left out, as are empty files. Not done with Brief, Plan or
+	// StatOnly, or when comparing files.
+	FindDuplicates bool
+	// Maximum size in bytes of files to compare, zero meaning unlimited. Pairs of files of equal sizes where either is
+	// larger are reported as TOO_LARGE without being read. Files of different sizes are still reported as differing
+	// unless compared as text or by their data.
+	MaxSize int64
+	// Minimum size in bytes of files to compare, zero meaning unlimited. Pairs of files which are both smaller are
+	// reported as TOO_SMALL without being read, even if their sizes differ, so they never count as differing.
+	MinSize int64
+}
+
+// validate checks the options for invalid values.
+func (o Options) validate() error {
+	for _, p := range o.Exclude {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", p, err)
+		}
+	}
+	for _, p := range o.Include {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid include pattern %q: %w", p, err)
+		}
+	}
+	for ext, name := range o.Comparators {
+		if comparators[name] == nil || name == compareDecompress {
+			return fmt.Errorf("unknown comparator %q for extension %q", name, ext)
+		}
+	}
+	if o.Hash != "" {
+		if _, err := newHash(o.Hash); err != nil {
+			return err
+		}
+	}
+	if o.Owner && !ownerSupported {
+		return errors.New("comparing owners is not supported on this platform")
+	}
+	if o.Xattr && !xattrSupported {
+		return errors.New("comparing extended attributes is not supported on this platform")
+	}
+	if o.Attributes && !attributesSupported {
+		return errors.New("comparing attributes is not supported on this platform")
+	}
+	if o.SameFilesystem && !deviceSupported {
+		return errors.New("staying on the same filesystem is not supported on this platform")
+	}
+	return nil
+}
+
+// Difference is a single reported item. Which fields are set depends on the type.
+type Difference struct {
+	Type  string `json:"type"`
+	Path1 string `json:"path1,omitempty"`
+	Path2 string `json:"path2,omitempty"`
+	// Path in theirs, the third path, of an item of a three-way comparison.
+	Path3 string `json:"path3,omitempty"`
+	Dir   string `json:"dir,omitempty"`
+	Name  string `json:"name,omitempty"`
+	// Which of the two paths, 1 or 2, an item only present on one side is in, or which directory is empty. For items of
+	// three-way comparisons changed on one side only, 2 for mine or 3 for theirs.
+	Side  int    `json:"side,omitempty"`
+	Kind1 string `json:"kind1,omitempty"`
+	Kind2 string `json:"kind2,omitempty"`
+	// Sizes in bytes of files which differ, are planned to be compared or are left out by their sizes. Both are set for
+	// files which differ, except for readers compared by DiffReaders, which are only read up to their first difference,
+	// and files checked against a manifest by DiffManifest, which only holds their digests.
+	Size1 *int64 `json:"size1,omitempty"`
+	Size2 *int64 `json:"size2,omitempty"`
+	// Whether files were found to differ by their sizes alone, without comparing their contents.
+	BySize bool   `json:"by_size,omitempty"`
+	Mode1  string `json:"mode1,omitempty"`
+	Mode2  string `json:"mode2,omitempty"`
+	Time1  string `json:"time1,omitempty"`
+	Time2  string `json:"time2,omitempty"`
+	// Owners as uid:gid.
+	Owner1 string `json:"owner1,omitempty"`
+	Owner2 string `json:"owner2,omitempty"`
+	// Extended attributes which differ by name along with their values, leaving out those missing on either side.
+	Xattrs1 map[string]string `json:"xattrs1,omitempty"`
+	Xattrs2 map[string]string `json:"xattrs2,omitempty"`
+	// Windows attributes as a comma separated list such as readonly,hidden, or none.
+	Attributes1 string `json:"attributes1,omitempty"`
+	Attributes2 string `json:"attributes2,omitempty"`
+	// Zero based offset of the first differing byte, which is also the number of bytes which matched before it, if
+	// known. It is not known for files which differ by their sizes alone, which are compared other than byte for byte,
+	// or which are compared as text normalized by IgnoreTrailingNewline and the like, as offsets into the normalized
+	// text are not those in the files.
+	Offset *int64 `json:"offset,omitempty"`
+	// Paths of the values which differ in files compared by the data they hold, such as $.items[0].name. For files with
+	// several documents the path is prefixed by the number of the document, such as 2:$.metadata.name.
+	Paths []string `json:"paths,omitempty"`
+	// Whether files which differ are BINARY or TEXT, judging by their first SNIFF_SIZE bytes. Not set if only their
+	// sizes are compared, nor for files which differ by their sizes alone unless a hex dump is asked for.
+	Content string `json:"content,omitempty"`
+	// Hex dump of binary files which differ, if asked for, and whether it stopped before the end of the files.
+	Hex          []HexRow `json:"hex,omitempty"`
+	HexTruncated bool     `json:"hex_truncated,omitempty"`
+	// Pixel by pixel comparison of images which differ, if asked for.
+	Image *ImageDiff `json:"image,omitempty"`
+	// Rows and columns which differ in CSV files compared by the data they hold.
+	CSV *CSVDiff `json:"csv,omitempty"`
+	// Lines only in either text file of files compared as sets of lines.
+	Lines *LinesDiff `json:"lines,omitempty"`
+	// Whether files were compared by their decompressed contents, in which case Offset is counted in those.
+	Decompressed bool `json:"decompressed,omitempty"`
+	// Unified diff of text files which differ, if asked for. With Patch also the diff adding or deleting the text files
+	// of items only present on one side.
+	Diff string `json:"diff,omitempty"`
+	// Estimated percentage of the bytes of two differing files found in blocks common to both, if asked for. This is
+	// not a true edit distance.
+	Similarity *int `json:"similarity,omitempty"`
+	// Number of entries of the directory which is not empty when the other one is.
+	Entries int `json:"entries,omitempty"`
+	// Error which prevented an item from being compared, and the operation which failed with it if known, such as open
+	// or read.
+	Error string `json:"error,omitempty"`
+	Op    string `json:"op,omitempty"`
+	// Targets of symlinks which differ.
+	Target1 string `json:"target1,omitempty"`
+	Target2 string `json:"target2,omitempty"`
+	// Paths of files with identical contents found with FindDuplicates, in either directory, in order.
+	Duplicates []string `json:"duplicates,omitempty"`
+	// Numbers of the devices device files which differ stand for, as major:minor. Kind1 is the kind of both files.
+	Device1 string `json:"device1,omitempty"`
+	Device2 string `json:"device2,omitempty"`
+}
+
+// IsDifference returns whether d is an actual difference. Common subdirectories are reported when not recursing,
+// symlink loops are reported as warnings and identical files are reported if asked to, but none of them mean the
+// paths differ.
+func (d Difference) IsDifference() bool {
+	switch d.Type {
+	case COMMON_SUBDIR, SYMLINK_LOOP, IDENTICAL, PLANNED, SKIPPED, MOUNT_POINT, INVALID_JSON, INVALID_YAML, INVALID_IMAGE,
+		INVALID_CSV, BINARY_FILE, TOO_LARGE, TOO_SMALL, DUPLICATES:
+		return false
+	}
+	return !d.Incomplete()
+}
+
+// Incomplete returns whether d means an item could not be compared, such as a broken symlink or an unreadable file.
+// Such items are neither equal nor different.
+func (d Difference) Incomplete() bool {
+	return d.Type == BROKEN_SYMLINK || d.Type == PERMISSION_DENIED || d.Type == READ_ERROR || d.Type == ERROR
+}
+
+// comparer holds the shared state of a single comparison run across goroutines.
+type comparer struct {
+	opts    Options
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	diffs   []Difference
+	out     chan Difference
+	err     error
+	parent  context.Context
+	ctx     context.Context
+	cancel  context.CancelFunc
+	sem     chan struct{}
+	remotes map[string]*sftpFS
+	fsys    [2]filesystem
+	devices [2]uint64
+	// The compared paths, which paths in patches are relative to.
+	roots [2]string
+	// The .gitignore patterns which apply to each directory, by side, if Gitignore is set.
+	ignores [2]map[string]*gitignore
+	// The pairs of files compared so far, if Checkpoint is set.
+	checkpoint *checkpoint
+	// Number of differences recorded, counted if Limit is set.
+	found int
+	// Number of entries of a directory read at a time, DIR_BATCH_SIZE unless changed by tests.
+	batch int
+}
+
+// newComparer returns a comparer whose work is stopped when ctx is done.
+func newComparer(ctx context.Context, opts Options) *comparer {
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	c := &comparer{
+		opts:    opts,
+		diffs:   []Difference{},
+		parent:  ctx,
+		sem:     make(chan struct{}, jobs),
+		remotes: make(map[string]*sftpFS),
+		ignores: [2]map[string]*gitignore{make(map[string]*gitignore), make(map[string]*gitignore)},
+		batch:   DIR_BATCH_SIZE,
+	}
+	if opts.FS1 != nil {
+		c.fsys[0] = ioFS{opts.FS1}
+	}
+	if opts.FS2 != nil {
+		c.fsys[1] = ioFS{opts.FS2}
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	return c
+}
+
+// spawn runs f in a new goroutine, or in the calling one if comparing sequentially.
+func (c *comparer) spawn(f func()) {
+	if c.opts.Sequential {
+		f()
+		return
+	}
+	go f()
+}
+
+// acquire blocks until a job slot is available. This bounds the number of open file descriptors. It returns false
+// without acquiring a slot if work has been stopped.
+func (c *comparer) acquire() bool {
+	select {
+	case c.sem <- struct{}{}:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// release frees a job slot acquired by acquire.
+func (c *comparer) release() {
+	<-c.sem
+}
+
+// report records a difference, or sends it when streaming. In brief mode it stops all outstanding work after the first
+// difference, and with Limit after that many. It is safe to call from multiple goroutines.
+func (c *comparer) report(d Difference) {
+	if !c.record(d) {
+		return
+	}
+	if c.opts.OnResult != nil {
+		c.opts.OnResult(d)
+	}
+	if c.out == nil {
+		return
+	}
+
+	// The difference is sent without holding the lock so that a slow receiver only blocks the goroutine reporting it.
+	select {
+	case c.out <- d:
+	case <-c.parent.Done():
+	}
+}
+
+// record counts a difference and collects it unless streaming. It returns false if the difference is dropped.
+func (c *comparer) record(d Difference) bool {
+	if c.opts.Result != nil {
+		c.opts.Result.add(d)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.opts.Brief {
+		if !d.IsDifference() || c.stopped() {
+			return false
+		}
+		c.stop()
+	}
+	if c.opts.Limit > 0 {
+		if c.found >= c.opts.Limit {
+			return false
+		}
+		if d.IsDifference() {
+			if c.found++; c.found == c.opts.Limit {
+				c.stop()
+			}
+		}
+	}
+	if c.opts.Stats != nil {
+		c.opts.Stats.count(d)
+	}
+	if c.checkpoint != nil {
+		c.checkpoint.add(d)
+	}
+	if c.out == nil {
+		c.diffs = append(c.diffs, d)
+	}
+	return true
+}
+
+// fail records the first error encountered and stops all outstanding work. Permission errors and errors reading the
+// contents of a file only affect the path they occurred on, so they are reported instead and the comparison continues.
+func (c *comparer) fail(err error) {
+	var pathErr *fs.PathError
+	if errors.Is(err, fs.ErrPermission) && errors.As(err, &pathErr) {
+		c.report(Difference{Type: PERMISSION_DENIED, Path1: pathErr.Path, Error: pathErr.Err.Error(), Op: pathErr.Op})
+		return
+	}
+	if errors.As(err, &pathErr) && pathErr.Op == "read" {
+		c.report(Difference{Type: READ_ERROR, Path1: pathErr.Path, Error: pathErr.Err.Error(), Op: pathErr.Op})
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.stop()
+}
+
+// ancestry is a chain of directories being compared, identified by their real paths, from a directory up to the
+// directories the comparison started from. Directories on one side only have the other path empty.
+type ancestry struct {
+	key    [2]string
+	parent *ancestry
+}
+
+// contains returns whether a directory pair is in the chain.
+func (a *ancestry) contains(key [2]string) bool {
+	for ; a != nil; a = a.parent {
+		if a.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// visit returns the ancestry of a pair of directories below the directories up, identified by their real paths. ok is
+// false if the pair is already among them, which happens when symlinks lead back to a directory pair being compared.
+// Directories reached several times through symlinks which do not loop are compared each time.
+func (c *comparer) visit(dir1 string, dir2 string, up *ancestry) (a *ancestry, ok bool, err error) {
+	real1, err := c.fs(1, dir1).RealPath(dir1)
+	if err != nil {
+		return nil, false, err
+	}
+	real2, err := c.fs(2, dir2).RealPath(dir2)
+	if err != nil {
+		return nil, false, err
+	}
+
+	key := [2]string{real1, real2}
+	if up.contains(key) {
+		return nil, false, nil
+	}
+	return &ancestry{key: key, parent: up}, true, nil
+}
+
+// stop signals all goroutines to stop. It is safe to call multiple times.
+func (c *comparer) stop() {
+	c.cancel()
+}
+
+// stopped returns whether work has been stopped, either by the comparer itself or because its context is done.
+func (c *comparer) stopped() bool {
+	return c.ctx.Err() != nil
+}
+
+// Files compares two files byte for byte and returns whether they are equal or not.
+func Files(file1 string, file2 string) (bool, error) {
+	d, err := newComparer(context.Background(), Options{}).cmpFiles(file1, file2)
+	return d == nil && err == nil, err
+}
+
+// Readers compares two readers byte for byte and returns whether they are equal or not.
+func Readers(r1 io.Reader, r2 io.Reader) (bool, error) {
+	eq, _, err := newComparer(context.Background(), Options{}).cmpReaders(r1, r2)
+	return eq, err
+}
+
+// DiffReaders compares two readers byte for byte and returns the differences between them. The names are used as the
+// paths of the reported difference. Options which only apply to files or directories are ignored. If ctx is done
+// before the comparison finishes its error is returned.
+func DiffReaders(
+	ctx context.Context, name1 string, r1 io.Reader, name2 string, r2 io.Reader, opts Options,
+) ([]Difference, error) {
+	c := newComparer(ctx, opts)
+	defer c.cancel()
+	eq, offset, err := c.cmpContents(r1, r2)
+	c.compared()
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if !eq {
+		d := Difference{Type: FILES_DIFFER, Path1: name1, Path2: name2}
+		if offset >= 0 {
+			d.Offset = &offset
+		}
+		c.report(d)
+	} else if opts.ReportIdentical {
+		c.report(Difference{Type: IDENTICAL, Path1: name1, Path2: name2})
+	}
+	return c.diffs, nil
+}
+
+// Dirs compares two directories (recursively if specified) and returns the differences between them. The first error
+// encountered stops the comparison and is returned. If ctx is done before the comparison finishes its error is
+// returned.
+func Dirs(ctx context.Context, dir1 string, dir2 string, opts Options) ([]Difference, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	c := newComparer(ctx, opts)
+	c.roots = [2]string{dir1, dir2}
+	if err := c.connect(dir1, dir2); err != nil {
+		return nil, err
+	}
+	if err := c.openCheckpoint(); err != nil {
+		c.disconnect()
+		return nil, err
+	}
+	c.wg.Add(1)
+	c.spawn(func() { c.diffDirs(dir1, dir2, "", 0, nil) })
+	c.wg.Wait()
+	c.findDuplicates(dir1, dir2)
+	return c.wait()
+}
+
+// DiffFiles compares two files and returns the differences between them. Only the options which apply to files are
+// used. If both files are zip or tar archives they are compared entry by entry instead, as if they were directories.
+// If ctx is done before the comparison finishes its error is returned.
+func DiffFiles(ctx context.Context, file1 string, file2 string, opts Options) ([]Difference, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	c := newComparer(ctx, opts)
+	c.roots = [2]string{file1, file2}
+	if err := c.connect(file1, file2); err != nil {
+		return nil, err
+	}
+	if err := c.openCheckpoint(); err != nil {
+		c.disconnect()
+		return nil, err
+	}
+	c.startFiles(file1, file2)
+	return c.wait()
+}
+
+// Diff compares two paths, which must either both be directories or both be files, and streams the differences
+// between them as they are found. The returned channel is closed once the comparison is done. If an error stops the
+// comparison it is sent as a difference of type ERROR before the channel is closed. If ctx is done the comparison
+// stops and the channel is closed, in which case the differences received are incomplete and the caller should check
+// ctx.Err().
+func Diff(ctx context.Context, path1 string, path2 string, opts Options) (<-chan Difference, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	c := newComparer(ctx, opts)
+	c.roots = [2]string{path1, path2}
+	if err := c.connect(path1, path2); err != nil {
+		return nil, err
+	}
+	if err := c.openCheckpoint(); err != nil {
+		c.disconnect()
+		return nil, err
+	}
+	stat1, err1 := c.stat(1, path1)
+	stat2, err2 := c.stat(2, path2)
+	// With AllowMissing only one of the paths may be missing, the side of which is kept.
+	missing := 0
+	if c.opts.AllowMissing && errors.Is(err1, fs.ErrNotExist) && err2 == nil {
+		missing, err1 = 1, nil
+	} else if c.opts.AllowMissing && errors.Is(err2, fs.ErrNotExist) && err1 == nil {
+		missing, err2 = 2, nil
+	}
+	for _, err := range []error{err1, err2} {
+		if err != nil {
+			c.disconnect()
+			return nil, err
+		}
+	}
+	if missing == 0 && stat1.IsDir() != stat2.IsDir() {
+		c.disconnect()
+		return nil, fmt.Errorf("cannot compare between a file and a directory: %v and %v", path1, path2)
+	}
+
+	c.out = make(chan Difference)
+	dirs := missing == 0 && stat1.IsDir()
+	switch {
+	case missing == 1:
+		c.wg.Add(1)
+		go c.diffMissing(2, path2, stat2)
+	case missing == 2:
+		c.wg.Add(1)
+		go c.diffMissing(1, path1, stat1)
+	case dirs:
+		c.wg.Add(1)
+		go c.diffDirs(path1, path2, "", 0, nil)
+	default:
+		c.startFiles(path1, path2)
+	}
+	go func() {
+		c.wg.Wait()
+		if dirs {
+			c.findDuplicates(path1, path2)
+		}
+		c.cancel()
+		c.disconnect()
+		if c.err != nil {
+			select {
+			case c.out <- Difference{Type: ERROR, Error: c.err.Error()}:
+			case <-ctx.Done():
+			}
+		}
+		close(c.out)
+	}()
+	return c.out, nil
+}
+
+// startFiles starts comparing two files, or two archives entry by entry.
+func (c *comparer) startFiles(file1 string, file2 string) {
+	c.wg.Add(1)
+	if !c.opts.StatOnly && !c.opts.NoArchives && c.archiveOpener(1, file1) != nil && c.archiveOpener(2, file2) != nil {
+		go c.diffArchives(file1, file2)
+	} else {
+		go c.diffFiles(file1, file2)
+	}
+}
+
+// connect connects to the remote hosts of any remote paths, in the order of their sides. Paths on a filesystem given in
+// the options are never remote.
+func (c *comparer) connect(paths ...string) error {
+	for i, p := range paths {
+		prefix, _, ok := splitRemote(p)
+		if !ok || c.fsys[i] != nil || c.remotes[prefix] != nil {
+			continue
+		}
+		r, err := dialRemote(prefix)
+		if err != nil {
+			c.disconnect()
+			return fmt.Errorf("cannot connect to %v: %w", strings.TrimSuffix(prefix, ":"), err)
+		}
+		c.remotes[prefix] = r
+	}
+	return nil
+}
+
+// disconnect closes the connections to all remote hosts, and the checkpoint file.
+func (c *comparer) disconnect() {
+	for _, r := range c.remotes {
+		r.Close()
+	}
+	if c.checkpoint != nil {
+		c.checkpoint.close()
+	}
+}
+
+// wait waits for all goroutines to finish and returns the collected differences or the first error. The differences
+// are incomplete if the parent context is done, so its error is returned instead.
+func (c *comparer) wait() ([]Difference, error) {
+	c.wg.Wait()
+	c.cancel()
+	c.disconnect()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.parent.Err() != nil {
+		return nil, c.parent.Err()
+	}
+	return c.diffs, nil
+}
+
+// cmpFiles compares two files byte for byte, or by their digests if a hash is set, and returns how they differ, or nil
+// if they are equal. If the files were compared byte for byte the difference holds the zero based offset of their first
+// differing byte, and if they were found to differ by their sizes alone it is marked as BySize. The difference holds
+// the class of their contents if it is known from the bytes read.
+func (c *comparer) cmpFiles(file1 string, file2 string) (*Difference, error) {
+	// Open both files and get their stats.
+	f1, err := c.open(1, file1)
+	if err != nil {
+		return nil, err
+	}
+	defer f1.Close()
+
+	stat1, err := f1.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	f2, err := c.open(2, file2)
+	if err != nil {
+		return nil, err
+	}
+	defer f2.Close()
+
+	stat2, err := f2.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Files compared by reading them are classified by the bytes read, so that they need not be read again.
+	s1, s2 := &sniffer{r: f1}, &sniffer{r: f2}
+	sniff := func(d *Difference, err error) (*Difference, error) {
+		if d != nil {
+			d.Content, _ = sniffed(s1, s2)
+		}
+		return d, err
+	}
+
+	// Text files of different sizes may still be the same once normalized, so they are always read.
+	if c.text() {
+		return sniff(offsetDiff(c.cmpText(s1, s2)))
+	}
+
+	// If files have different sizes they cannot be same. If only sizes are to be compared they are same otherwise, as are
+	// hardlinks to the same inode.
+	if stat1.Size() != stat2.Size() {
+		return &Difference{BySize: true}, nil
+	}
+	if c.opts.SizeOnly || sameInode(stat1, stat2) {
+		return nil, nil
+	}
+	if c.opts.Hash != "" {
+		eq, err := c.cmpHashes(file1, file2, c.opts.Hash)
+		if err == nil {
+			c.read(stat1.Size() + stat2.Size())
+		}
+		return offsetDiff(eq, -1, err)
+	}
+	// Only local files can be memory mapped.
+	osf1, ok1 := f1.(*os.File)
+	osf2, ok2 := f2.(*os.File)
+	if c.opts.Mmap && ok1 && ok2 {
+		if eq, offset, ok := c.cmpMmap(osf1, osf2, stat1.Size()); ok {
+			return offsetDiff(eq, offset, nil)
+		}
+	}
+	if c.opts.ThreadsPerFile > 1 && !c.opts.Sequential && stat1.Size() >= RANGES_MIN_SIZE {
+		return offsetDiff(c.cmpRanges(f1, f2, stat1.Size(), c.opts.ThreadsPerFile))
+	}
+
+	return sniff(offsetDiff(c.cmpReaders(s1, s2)))
+}
+
+// offsetDiff returns the difference of files compared with the result of a comparison returning whether they are
+// equal and the offset of their first differing byte, -1 if unknown. It is nil if they are equal.
+func offsetDiff(eq bool, offset int64, err error) (*Difference, error) {
+	if err != nil || eq {
+		return nil, err
+	}
+	d := &Difference{}
+	if offset >= 0 {
+		d.Offset = &offset
+	}
+	return d, nil
+}
+
+// cmpReaders compares two readers byte for byte until both end and returns whether they are equal or not. If they
+// differ the zero based offset of the first differing byte is also returned, otherwise the offset is -1. Unlike
+// cmpFiles it does not rely on knowing sizes up front, so it works on streams such as stdin.
+func (c *comparer) cmpReaders(r1 io.Reader, r2 io.Reader) (bool, int64, error) {
+	// Buffer the readers so that large reads are done while comparing in smaller chunks.
+	size := c.opts.BufferSize
+	if size <= 0 {
+		size = BUFFER_SIZE
+	}
+	r1 = bufio.NewReaderSize(r1, size)
+	r2 = bufio.NewReaderSize(r2, size)
+
+	// Read bytes in chunks and compare them. Stop early if asked to, the result will not be used anyway.
+	b1 := make([]byte, CHUNK_SIZE)
+	b2 := make([]byte, CHUNK_SIZE)
+	var pos int64
+	for {
+		if c.stopped() {
+			return true, -1, nil
+		}
+
+		// Chunks are read fully so that short reads, common for pipes, do not cause spurious differences. A partial
+		// chunk means the reader has ended.
+		n1, err1 := io.ReadFull(r1, b1)
+		n2, err2 := io.ReadFull(r2, b2)
+		c.read(int64(n1 + n2))
+		if err1 != nil && err1 != io.EOF && err1 != io.ErrUnexpectedEOF {
+			return false, -1, err1
+		}
+		if err2 != nil && err2 != io.EOF && err2 != io.ErrUnexpectedEOF {
+			return false, -1, err2
+		}
+
+		// If the bytes read are not same readers are different. Only the bytes read in this iteration are compared as
+		// the rest of the buffers may hold stale data.
+		if n1 != n2 || !bytes.Equal(b1[:n1], b2[:n2]) {
+			return false, pos + int64(firstMismatch(b1[:n1], b2[:n2])), nil
+		}
+
+		// If both readers end at the same time they are the same.
+		if n1 < CHUNK_SIZE {
+			return true, -1, nil
+		}
+		pos += int64(n1)
+	}
+}
+
+// firstMismatch returns the index of the first differing byte of two slices. If one is a prefix of the other, the
+// length of the shorter one is returned.
+func firstMismatch(b1 []byte, b2 []byte) int {
+	n := min(len(b1), len(b2))
+	for i := 0; i < n; i++ {
+		if b1[i] != b2[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// diffFiles compares two files and reports whether they are different. Should be called via a goroutine.
+func (c *comparer) diffFiles(file1 string, file2 string) {
+	defer c.wg.Done()
+	if c.stopped() {
+		return
+	}
+	if c.checkpoint != nil {
+		if c.resumed(file1, file2) {
+			return
+		}
+		defer c.checkpointed(file1, file2)
+	}
+	if c.opts.MinSize > 0 || (c.opts.MaxSize > 0 && !c.opts.StatOnly) {
+		skip, err := c.outOfRange(file1, file2)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		if skip {
+			return
+		}
+	}
+	if c.opts.Plan {
+		c.plan(file1, file2)
+		return
+	}
+	if c.opts.OnStart != nil {
+		c.opts.OnStart(file1, file2)
+	}
+
+	// Files are compared with the comparator for their format, falling back to comparing them byte for byte if they
+	// turn out not to be in that format. Either way they count as compared once.
+	var found *Difference
+	var err error
+	if !c.opts.StatOnly {
+		if !c.acquire() {
+			return
+		}
+		var ok bool
+		found, ok, err = comparators[c.comparatorName(file1, file2)](c, file1, file2)
+		if !ok && err == nil {
+			found, _, err = cmpBytes(c, file1, file2)
+		}
+		c.compared()
+		c.release()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+	}
+
+	stat1, err := c.stat(1, file1)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	stat2, err := c.stat(2, file2)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+
+	// Files compared by their metadata alone are only compared by their sizes here.
+	if c.opts.StatOnly {
+		if stat1.Size() != stat2.Size() {
+			found = &Difference{BySize: true}
+		}
+		c.compared()
+	}
+
+	if found != nil {
+		size1, size2 := stat1.Size(), stat2.Size()
+		d := *found
+		d.Type, d.Path1, d.Path2, d.Size1, d.Size2 = FILES_DIFFER, file1, file2, &size1, &size2
+		offset := int64(0)
+		if d.Offset != nil {
+			offset = *d.Offset
+		}
+		// The contents of compressed files are not labelled, dumped or diffed, since that would be done on the files
+		// as they are stored.
+		if c.opts.StatOnly || d.Decompressed {
+			c.report(d)
+			return
+		}
+		// Files found to differ by their sizes alone are only classified to decide on a hex dump.
+		if !c.opts.SizeOnly && d.Content == "" && (!d.BySize || c.opts.Hex) {
+			content, err := c.content(file1, file2)
+			if err != nil {
+				c.fail(err)
+				return
+			}
+			d.Content = content
+		}
+		// Files larger than MaxSize are not dumped, as that may read them whole.
+		if c.opts.Hex && d.Content == BINARY && (c.opts.MaxSize <= 0 || max(size1, size2) <= c.opts.MaxSize) {
+			if !c.acquire() {
+				return
+			}
+			d.Hex, d.HexTruncated, err = c.hexDump(file1, file2, offset)
+			c.release()
+			if err != nil {
+				c.fail(err)
+				return
+			}
+		}
+		// Images are decoded whole, so like hex dumps they are not compared if larger than MaxSize.
+		if c.opts.Image && isImage(file1) && isImage(file2) && (c.opts.MaxSize <= 0 || max(size1, size2) <= c.opts.MaxSize) {
+			if !c.acquire() {
+				return
+			}
+			d.Image, err = c.diffImages(file1, file2)
+			c.release()
+			if err != nil {
+				c.fail(err)
+				return
+			}
+		}
+		// Files are read whole to be diffed, so large ones are only reported as differing.
+		if (c.opts.Unified || c.opts.Patch) && d.Content != BINARY && max(size1, size2) <= UNIFIED_MAX_SIZE {
+			if !c.acquire() {
+				return
+			}
+			diff, ok, err := c.unifiedFiles(file1, file2, c.opts.Context)
+			c.release()
+			if err != nil {
+				c.fail(err)
+				return
+			}
+			if ok {
+				d.Diff = diff
+			}
+		}
+		if c.opts.Similarity {
+			if !c.acquire() {
+				return
+			}
+			similarity, err := c.similarity(file1, file2)
+			c.release()
+			if err != nil {
+				c.fail(err)
+				return
+			}
+			d.Similarity = &similarity
+		}
+		c.report(d)
+		return
+	}
+
+	// Files with equal contents may still differ in their metadata.
+	identical := true
+	if c.opts.Mode && stat1.Mode().Perm() != stat2.Mode().Perm() {
+		identical = false
+		c.report(Difference{
+			Type:  MODE_DIFFER,
+			Path1: file1,
+			Path2: file2,
+			Mode1: fmt.Sprintf("%04o", stat1.Mode().Perm()),
+			Mode2: fmt.Sprintf("%04o", stat2.Mode().Perm()),
+		})
+	}
+
+	if c.opts.Time {
+		drift := stat1.ModTime().Sub(stat2.ModTime())
+		if drift < 0 {
+			drift = -drift
+		}
+		if drift > c.opts.TimeTolerance {
+			identical = false
+			c.report(Difference{
+				Type:  TIME_DIFFER,
+				Path1: file1,
+				Path2: file2,
+				Time1: stat1.ModTime().Format(time.RFC3339Nano),
+				Time2: stat2.ModTime().Format(time.RFC3339Nano),
+			})
+		}
+	}
+
+	if c.opts.Owner {
+		owner1, ok1 := owner(stat1)
+		owner2, ok2 := owner(stat2)
+		if ok1 && ok2 && owner1 != owner2 {
+			identical = false
+			c.report(Difference{Type: OWNER_DIFFER, Path1: file1, Path2: file2, Owner1: owner1, Owner2: owner2})
+		}
+	}
+
+	if c.opts.Attributes {
+		attrs1, ok1 := attributes(stat1)
+		attrs2, ok2 := attributes(stat2)
+		if ok1 && ok2 && attrs1 != attrs2 {
+			identical = false
+			c.report(Difference{
+				Type:        ATTRIBUTES_DIFFER,
+				Path1:       file1,
+				Path2:       file2,
+				Attributes1: attrs1,
+				Attributes2: attrs2,
+			})
+		}
+	}
+
+	if c.opts.Xattr {
+		d, err := c.diffXattrs(file1, file2)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		if d != nil {
+			identical = false
+			c.report(*d)
+		}
+	}
+
+	if identical && c.opts.ReportIdentical {
+		c.report(Difference{Type: IDENTICAL, Path1: file1, Path2: file2})
+	}
+}
+
+// plan reports a pair of files as planned to be compared, along with their sizes.
+func (c *comparer) plan(file1 string, file2 string) {
+	stat1, err := c.stat(1, file1)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	stat2, err := c.stat(2, file2)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	size1, size2 := stat1.Size(), stat2.Size()
+	c.report(Difference{Type: PLANNED, Path1: file1, Path2: file2, Size1: &size1, Size2: &size2})
+}
+
+// outOfRange reports two files as too small or too large to compare and returns true if both are smaller than MinSize,
+// or if either is larger than MaxSize and reading them is needed to tell whether they differ, which it is not if their
+// sizes differ unless they are compared as text or by their data. Files are never too large when compared by their
+// metadata alone, as they are not read then.
+func (c *comparer) outOfRange(file1 string, file2 string) (bool, error) {
+	stat1, err := c.stat(1, file1)
+	if err != nil {
+		return false, err
+	}
+	stat2, err := c.stat(2, file2)
+	if err != nil {
+		return false, err
+	}
+	size1, size2 := stat1.Size(), stat2.Size()
+	if max(size1, size2) < c.opts.MinSize {
+		c.report(Difference{Type: TOO_SMALL, Path1: file1, Path2: file2, Size1: &size1, Size2: &size2})
+		return true, nil
+	}
+	if c.opts.MaxSize <= 0 || c.opts.StatOnly || max(size1, size2) <= c.opts.MaxSize ||
+		(size1 != size2 && !c.text() && !c.semantic(file1, file2)) {
+		return false, nil
+	}
+	c.report(Difference{Type: TOO_LARGE, Path1: file1, Path2: file2, Size1: &size1, Size2: &size2})
+	return true, nil
+}
+
+// excluded returns whether the entry at the given relative path matches any exclude pattern.
+func (c *comparer) excluded(rel string) bool {
+	return matches(c.opts.Exclude, rel)
+}
+
+// hidden returns whether the entry at the given relative path is hidden, or inside a hidden directory.
+func hidden(rel string) bool {
+	for _, name := range strings.Split(rel, "/") {
+		if strings.HasPrefix(name, ".") && name != "." && name != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// skipped returns whether the entry at the given relative path is left out of the comparison, either because it is
+// excluded or hidden or because it is a file which is not included.
+func (c *comparer) skipped(rel string, dir bool) bool {
+	if c.excluded(rel) || (c.opts.NoHidden && hidden(rel)) {
+		return true
+	}
+	return !dir && len(c.opts.Include) > 0 && !matches(c.opts.Include, rel)
+}
+
+// matches returns whether the entry at the given relative path matches any of the patterns, either by its name or by
+// the whole path.
+func matches(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, path.Base(rel)); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filter removes skipped entries of the directory dir on the given side at the given relative path, including those
+// ignored by .gitignore files if Gitignore is set. Symlinks count as directories for Include and .gitignore patterns
+// only if they are followed and lead to one.
+func (c *comparer) filter(side int, dir string, rel string, entries []fs.DirEntry) []fs.DirEntry {
+	var ignore *gitignore
+	if c.opts.Gitignore {
+		ignore = c.gitignore(side, dir, rel, entries)
+	}
+	return c.filterIgnored(side, dir, rel, entries, ignore)
+}
+
+// filterIgnored is filter with the .gitignore patterns which apply to the directory already loaded, if Gitignore is
+// set, for entries read a batch at a time.
+func (c *comparer) filterIgnored(
+	side int, dir string, rel string, entries []fs.DirEntry, ignore *gitignore,
+) []fs.DirEntry {
+	if len(c.opts.Exclude) == 0 && len(c.opts.Include) == 0 && !c.opts.Gitignore && !c.opts.NoHidden {
+		return entries
+	}
+
+	filtered := entries[:0]
+	for _, e := range entries {
+		d := e.IsDir()
+		if isLink(e) && c.follows(side) {
+			d, _ = c.isDir(side, dir, e)
+		}
+		r := path.Join(rel, e.Name())
+		skip := c.skipped(r, d)
+		if c.opts.Gitignore && !skip {
+			skip = (d && e.Name() == ".git") || ignore.ignored(r, d)
+		}
+		if !skip {
+			filtered = append(filtered, e)
+		} else if c.opts.Plan {
+			c.report(Difference{Type: SKIPPED, Path1: c.join(side, dir, e.Name())})
+		}
+	}
+	return filtered
+}
+
+// keys returns the keys by which entries are matched between two directories, in the order of the entries. Normally
+// this is just the name but when ignoring case it is the lowercased name, and when decompressing files their names lose
+// any compression extension, so that a.json matches a.json.gz. Keys which would collide with another entry's are just
+// the name.
+func (c *comparer) keys(entries []fs.DirEntry) []string {
+	keys := make([]string, len(entries))
+	if !c.opts.IgnoreCase && !c.opts.Decompress {
+		for i, e := range entries {
+			keys[i] = e.Name()
+		}
+		return keys
+	}
+
+	counts := make(map[string]int, len(entries))
+	for i, e := range entries {
+		keys[i] = c.key(e)
+		counts[keys[i]]++
+	}
+	for i, e := range entries {
+		if counts[keys[i]] > 1 {
+			keys[i] = e.Name()
+		}
+	}
+	return keys
+}
+
+// key returns the key of a single entry, without falling back to its name if other entries share it.
+func (c *comparer) key(e fs.DirEntry) string {
+	key := e.Name()
+	if c.opts.Decompress && !e.IsDir() {
+		key = decompressedName(key)
+	}
+	if c.opts.IgnoreCase {
+		key = strings.ToLower(key)
+	}
+	return key
+}
+
+// byKey sorts entries along with their keys, in order of the keys.
+type byKey struct {
+	entries []fs.DirEntry
+	keys    []string
+}
+
+func (b byKey) Len() int           { return len(b.entries) }
+func (b byKey) Less(i, j int) bool { return b.keys[i] < b.keys[j] }
+func (b byKey) Swap(i, j int) {
+	b.entries[i], b.entries[j] = b.entries[j], b.entries[i]
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+}
+
+// sortByKey sorts entries in place in order of their keys, and returns the keys in the same order. Entries are usually
+// listed in order of their names already, which are their keys unless ignoring case or decompressing.
+func (c *comparer) sortByKey(entries []fs.DirEntry) []string {
+	keys := c.keys(entries)
+	if b := (byKey{entries, keys}); !sort.IsSorted(b) {
+		sort.Sort(b)
+	}
+	return keys
+}
+
+// isDir returns whether a directory entry on the given side is a directory, following it if it is a symlink.
+func (c *comparer) isDir(side int, dir string, e fs.DirEntry) (bool, error) {
+	if !isLink(e) {
+		return e.IsDir(), nil
+	}
+
+	stat, err := c.stat(side, c.join(side, dir, e.Name()))
+	if err != nil {
+		return false, err
+	}
+	return stat.IsDir(), nil
+}
+
+// mountPoint returns whether either of two subdirectories is on a different device than the compared directory on its
+// side, if asked to stay on the same filesystem. Subdirectories which cannot be checked are not considered mount
+// points, any error is left for reading them to report.
+func (c *comparer) mountPoint(dir1 string, dir2 string) bool {
+	if !c.opts.SameFilesystem {
+		return false
+	}
+	for i, dir := range []string{dir1, dir2} {
+		stat, err := c.stat(i+1, dir)
+		if err != nil {
+			continue
+		}
+		if dev, ok := device(stat); ok && dev != c.devices[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// isLink returns whether a directory entry is a symlink.
+func isLink(e fs.DirEntry) bool {
+	return e.Type()&fs.ModeSymlink != 0
+}
+
+// follows returns whether symlinks are followed on the given side.
+func (c *comparer) follows(side int) bool {
+	return c.opts.FollowSymlinks || (side == 1 && c.opts.FollowSymlinks1) || (side == 2 && c.opts.FollowSymlinks2)
+}
+
+// kind returns the kind of a directory entry, without following symlinks, as used in type mismatches.
+func kind(e fs.DirEntry) string {
+	if isLink(e) {
+		return "symlink"
+	} else if e.IsDir() {
+		return "directory"
+	}
+	return "file"
+}
+
+// kind returns the kind of a directory entry on the given side like kind, except that symlinks followed on that side
+// are of the kind they point to. Broken symlinks remain symlinks.
+func (c *comparer) kind(side int, dir string, e fs.DirEntry) string {
+	if !isLink(e) || !c.follows(side) {
+		return kind(e)
+	}
+	d, err := c.isDir(side, dir, e)
+	if err != nil {
+		return kind(e)
+	} else if d {
+		return "directory"
+	}
+	return "file"
+}
+
+// diffLinks compares two symlinks by their targets and reports whether they are different.
+func (c *comparer) diffLinks(link1 string, link2 string) {
+	target1, err := c.fs(1, link1).Readlink(link1)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	target2, err := c.fs(2, link2).Readlink(link2)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+
+	if target1 != target2 {
+		c.report(Difference{Type: LINKS_DIFFER, Path1: link1, Path2: link2, Target1: target1, Target2: target2})
+	} else if c.opts.ReportIdentical {
+		c.report(Difference{Type: IDENTICAL, Path1: link1, Path2: link2})
+	}
+}
+
+// resolve returns whether a directory entry on the given side is a directory, following it if it is a symlink. ok is
+// false if it could not be determined, either because the entry is a broken symlink, which is reported, or because of
+// an error, which is recorded.
+func (c *comparer) resolve(side int, dir string, e fs.DirEntry) (bool, bool) {
+	d, err := c.isDir(side, dir, e)
+	if errors.Is(err, fs.ErrNotExist) && isLink(e) {
+		c.report(Difference{Type: BROKEN_SYMLINK, Path1: c.join(side, dir, e.Name())})
+		return false, false
+	}
+	if err != nil {
+		c.fail(err)
+		return false, false
+	}
+	return d, true
+}
+
+// diffDirs compares two directories (recursively if specified) and reports which items are different. rel is the path
+// of the directories relative to the directories the comparison started from, depth is the number of levels below them
+// and up is the ancestry of the directories above. Should be called via a goroutine.
+func (c *comparer) diffDirs(dir1 string, dir2 string, rel string, depth int, up *ancestry) {
+	defer c.wg.Done()
+	if c.stopped() {
+		return
+	}
+
+	// Skip the directories if they are already being compared above, to avoid looping forever through symlinks.
+	dirs, ok, err := c.visit(dir1, dir2, up)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	if !ok {
+		c.report(Difference{Type: SYMLINK_LOOP, Path1: dir1, Path2: dir2})
+		return
+	}
+
+	// The devices of the compared directories are recorded before any subdirectory is compared.
+	if c.opts.SameFilesystem && depth == 0 {
+		for i, dir := range []string{dir1, dir2} {
+			stat, err := c.stat(i+1, dir)
+			if err != nil {
+				c.fail(err)
+				return
+			}
+			c.devices[i], _ = device(stat)
+		}
+	}
+
+	// Read both directories concurrently, as each read may have to wait on a slow filesystem, unless comparing
+	// sequentially.
+	if !c.acquire() {
+		return
+	}
+	var l2 *listing
+	var err2 error
+	done := make(chan struct{})
+	c.spawn(func() {
+		l2, err2 = c.list(2, dir2, rel)
+		close(done)
+	})
+	l1, err := c.list(1, dir1, rel)
+	<-done
+	c.release()
+	if err == nil {
+		defer l1.close()
+	}
+	if err2 == nil {
+		defer l2.close()
+	}
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	if err2 != nil {
+		c.fail(err2)
+		return
+	}
+
+	// If only one directory is empty, every entry of the other one is only in it, which is reported as a single item.
+	// Patches need the entries reported one by one, as each is added or deleted whole. The entries are still read to
+	// count them as examined.
+	empty := !c.opts.Patch && (l1.n == 0) != (l2.n == 0)
+	if empty && c.opts.Stats == nil {
+		c.reportEmpty(dir1, dir2, l1, l2)
+		return
+	}
+
+	// Match the entries of both directories in a merge join over their listings in order of their keys, so that each
+	// entry is dropped once both listings have been read past it and the memory used for a directory with more than a
+	// batch of entries does not grow with their number. Items not present in the other directory are differences,
+	// which are collected so that they can be checked for renames before being reported.
+	var e Examined
+	var only1, only2 []fs.DirEntry
+	for {
+		key1, ok1 := l1.peek()
+		key2, ok2 := l2.peek()
+		if !ok1 && !ok2 {
+			break
+		}
+		key := key1
+		if !ok1 || ok2 && key2 < key1 {
+			key = key2
+		}
+		var g1, g2 []fs.DirEntry
+		if ok1 && key1 == key {
+			g1 = l1.next()
+		}
+		if ok2 && key2 == key {
+			g2 = l2.next()
+		}
+		pairs, o1, o2 := match(key, g1, g2)
+		if c.opts.Stats != nil {
+			for _, p := range pairs {
+				e.add(c.examinedDir(1, dir1, p[0]) || c.examinedDir(2, dir2, p[1]))
+			}
+			for _, f := range o1 {
+				e.add(c.examinedDir(1, dir1, f))
+			}
+			for _, f := range o2 {
+				e.add(c.examinedDir(2, dir2, f))
+			}
+		}
+		if c.stopped() {
+			return
+		}
+		if empty {
+			continue
+		}
+		for _, p := range pairs {
+			c.diffEntries(dir1, dir2, p[0], p[1], rel, depth, dirs)
+		}
+		only1, only2 = append(only1, o1...), append(only2, o2...)
+	}
+	for _, err := range []error{l1.err, l2.err} {
+		if err != nil {
+			c.fail(err)
+			return
+		}
+	}
+	if c.opts.Stats != nil {
+		c.addExamined(rel, e)
+	}
+	if empty {
+		c.reportEmpty(dir1, dir2, l1, l2)
+		return
+	}
+
+	if c.opts.DetectRenames && !c.opts.Plan && !c.opts.StatOnly {
+		only1, only2 = c.detectRenames(dir1, dir2, only1, only2)
+		if c.stopped() {
+			return
+		}
+	}
+	for _, f := range only1 {
+		c.reportOnly(1, dir1, f.Name())
+	}
+	for _, f := range only2 {
+		c.reportOnly(2, dir2, f.Name())
+	}
+}
+
+// reportEmpty reports the one of two directories whose listing has no entries as empty, along with the number of
+// entries of the other one.
+func (c *comparer) reportEmpty(dir1 string, dir2 string, l1 *listing, l2 *listing) {
+	if l1.n == 0 {
+		c.report(Difference{Type: EMPTY_DIR, Path1: dir1, Path2: dir2, Side: 1, Entries: l2.n})
+	} else {
+		c.report(Difference{Type: EMPTY_DIR, Path1: dir1, Path2: dir2, Side: 2, Entries: l1.n})
+	}
+}
+
+// diffEntries compares an entry of a directory with the entry of the same key in the other directory, dir1 and dir2 at
+// the relative path rel and depth below the directories up. The entries carry the types given by Lstat, so symlinks are
+// only followed, and a symlink to a directory compared as a directory, if they are followed on their side.
+func (c *comparer) diffEntries(
+	dir1 string, dir2 string, f fs.DirEntry, f2 fs.DirEntry, rel string, depth int, up *ancestry,
+) {
+	path1 := c.join(1, dir1, f.Name())
+	path2 := c.join(2, dir2, f2.Name())
+	link1, link2 := isLink(f) && !c.follows(1), isLink(f2) && !c.follows(2)
+	if link1 && link2 {
+		c.diffLinks(path1, path2)
+		return
+	}
+	if link1 || link2 {
+		kind1, kind2 := c.kind(1, dir1, f), c.kind(2, dir2, f2)
+		c.report(Difference{Type: TYPE_MISMATCH, Path1: path1, Path2: path2, Kind1: kind1, Kind2: kind2})
+		return
+	}
+
+	isDir1, ok1 := c.resolve(1, dir1, f)
+	isDir2, ok2 := c.resolve(2, dir2, f2)
+	if !ok1 || !ok2 {
+		return
+	}
+
+	if !isDir1 && !isDir2 {
+		// Special files are compared here without being read. Followed symlinks may also lead to them.
+		if (isSpecial(f) || isSpecial(f2) || isLink(f) || isLink(f2)) && c.diffSpecial(path1, path2) {
+			return
+		}
+		c.wg.Add(1)
+		c.spawn(func() { c.diffFiles(path1, path2) })
+	} else if isDir1 && isDir2 {
+		if c.opts.Recursive && (c.opts.MaxDepth <= 0 || depth < c.opts.MaxDepth) {
+			if c.mountPoint(path1, path2) {
+				c.report(Difference{Type: MOUNT_POINT, Path1: path1, Path2: path2})
+				return
+			}
+			c.wg.Add(1)
+			c.spawn(func() { c.diffDirs(path1, path2, path.Join(rel, f.Name()), depth+1, up) })
+		} else {
+			c.report(Difference{Type: COMMON_SUBDIR, Path1: path1, Path2: path2})
+		}
+	} else if isDir1 && !isDir2 {
+		c.report(Difference{Type: TYPE_MISMATCH, Path1: path1, Path2: path2, Kind1: "directory", Kind2: "file"})
+	} else {
+		c.report(Difference{Type: TYPE_MISMATCH, Path1: path1, Path2: path2, Kind1: "file", Kind2: "directory"})
+	}
+}
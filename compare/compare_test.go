@@ -0,0 +1,299 @@
+package compare
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates files below dir by their slash separated relative paths, along with the directories above them.
+func writeFiles(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestPartialFinalChunk checks that files differing only in the tail of their final chunk, which is shorter than the
+// buffer, are found to differ at the right offset, and that equal files are not.
+func TestPartialFinalChunk(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "abcdefghij", "b": "abcdefghiX", "c": "abcdefghij"})
+	opts := Options{BufferSize: 4}
+
+	diffs, err := DiffFiles(context.Background(), filepath.Join(dir, "a"), filepath.Join(dir, "b"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].Type != FILES_DIFFER {
+		t.Fatalf("got %+v, want a single %v", diffs, FILES_DIFFER)
+	}
+	if diffs[0].Offset == nil || *diffs[0].Offset != 9 {
+		t.Errorf("got offset %v, want 9", diffs[0].Offset)
+	}
+
+	diffs, err = DiffFiles(context.Background(), filepath.Join(dir, "a"), filepath.Join(dir, "c"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("got %+v for equal files, want none", diffs)
+	}
+}
+
+// countTypes returns the number of differences of each type.
+func countTypes(diffs []Difference) map[string]int {
+	counts := make(map[string]int)
+	for _, d := range diffs {
+		counts[d.Type]++
+	}
+	return counts
+}
+
+// TestSymlinksToSameDir checks that a directory reached through several symlinks which do not loop is compared each
+// time, while a symlink leading back to a directory above is reported as a loop.
+func TestSymlinksToSameDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a/real/f": "x", "b/real/f": "y"})
+	for _, side := range []string{"a", "b"} {
+		for link, target := range map[string]string{"l1": "real", "l2": "real", "real/up": ".."} {
+			if err := os.Symlink(target, filepath.Join(dir, side, filepath.FromSlash(link))); err != nil {
+				t.Skip("cannot create symlinks:", err)
+			}
+		}
+	}
+
+	opts := Options{Recursive: true, FollowSymlinks: true}
+	diffs, err := Dirs(context.Background(), filepath.Join(dir, "a"), filepath.Join(dir, "b"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := countTypes(diffs)
+	if counts[FILES_DIFFER] != 3 || counts[SYMLINK_LOOP] != 3 {
+		t.Errorf("got %v, want 3 of %v through real, l1 and l2 and 3 of %v through their up", counts, FILES_DIFFER,
+			SYMLINK_LOOP)
+	}
+}
+
+// largeFiles creates two files of the given size with the same pseudo random contents and returns their paths.
+func largeFiles(tb testing.TB, size int) (string, string) {
+	tb.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	dir := tb.TempDir()
+	names := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+	for _, name := range names {
+		if err := os.WriteFile(name, data, 0o644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return names[0], names[1]
+}
+
+// BenchmarkCmpFiles compares two equal files of 16 MiB byte for byte with several buffer sizes.
+func BenchmarkCmpFiles(b *testing.B) {
+	const size = 16 * 1024 * 1024
+	file1, file2 := largeFiles(b, size)
+	for _, buffer := range []int{CHUNK_SIZE, BUFFER_SIZE, 1024 * 1024} {
+		b.Run(fmt.Sprintf("buffer=%v", buffer), func(b *testing.B) {
+			c := newComparer(context.Background(), Options{BufferSize: buffer})
+			b.SetBytes(2 * size)
+			for i := 0; i < b.N; i++ {
+				if d, err := c.cmpFiles(file1, file2); d != nil || err != nil {
+					b.Fatal(d, err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDirs compares two equal directories of 10000 files each by their metadata, so that the time is mostly spent
+// reading and matching their entries.
+func BenchmarkDirs(b *testing.B) {
+	dir := b.TempDir()
+	files := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		files[fmt.Sprintf("a/f%05d", i)] = "x"
+		files[fmt.Sprintf("b/f%05d", i)] = "x"
+	}
+	writeFiles(b, dir, files)
+	dir1, dir2 := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if diffs, err := Dirs(context.Background(), dir1, dir2, Options{StatOnly: true}); len(diffs) != 0 || err != nil {
+			b.Fatal(diffs, err)
+		}
+	}
+}
+
+// TestComparedOnce checks that a pair of files compared by the data they hold, which falls back to comparing them byte
+// for byte, counts as compared once.
+func TestComparedOnce(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a/bad.json": "{", "b/bad.json": "{", "a/ok.json": "{}", "b/ok.json": "{ }"})
+	var p Progress
+	opts := Options{JSONSemantic: true, Progress: &p}
+	if _, err := Dirs(context.Background(), filepath.Join(dir, "a"), filepath.Join(dir, "b"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.Files.Load(); n != 2 {
+		t.Errorf("got %v files compared, want 2", n)
+	}
+}
+
+// TestSniffedContent checks that files compared byte for byte are classified by the bytes read, including with a
+// buffer too small to hold the bytes sniffed, and that files which differ by their sizes alone are not classified.
+func TestSniffedContent(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"bin1": "ab\x00c", "bin2": "ab\x00d", "text1": "abc", "text2": "abd", "long": "abcd",
+	})
+	for _, test := range []struct {
+		file1, file2 string
+		buffer       int
+		want         string
+	}{
+		{"bin1", "bin2", 0, BINARY},
+		{"text1", "text2", 0, TEXT},
+		{"text1", "text2", 1, TEXT},
+		{"text1", "long", 0, ""},
+	} {
+		file1, file2 := filepath.Join(dir, test.file1), filepath.Join(dir, test.file2)
+		diffs, err := DiffFiles(context.Background(), file1, file2, Options{BufferSize: test.buffer})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diffs) != 1 || diffs[0].Content != test.want {
+			t.Errorf("%v and %v: got %+v, want content %q", test.file1, test.file2, diffs, test.want)
+		}
+	}
+}
+
+// diffDirsBatched compares two directories like Dirs, reading them the given number of entries at a time.
+func diffDirsBatched(t *testing.T, dir1 string, dir2 string, opts Options, batch int) []Difference {
+	t.Helper()
+	c := newComparer(context.Background(), opts)
+	c.roots = [2]string{dir1, dir2}
+	c.batch = batch
+	c.wg.Add(1)
+	c.spawn(func() { c.diffDirs(dir1, dir2, "", 0, nil) })
+	diffs, err := c.wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return diffs
+}
+
+// TestMergeJoin checks that matching the entries of two directories by a merge join finds the same items only in
+// either directory, and the same items differing, as looking each name up in a map of the other directory's entries,
+// both with the entries held in memory and read back from sorted batches.
+func TestMergeJoin(t *testing.T) {
+	dir := t.TempDir()
+	r := rand.New(rand.NewSource(1))
+	files := make(map[string]string)
+	names := [3]map[string]bool{nil, make(map[string]bool), make(map[string]bool)}
+	for i := 0; i < 500; i++ {
+		name := fmt.Sprintf("f%x", r.Intn(1000))
+		side := 1 + r.Intn(2)
+		names[side][name] = true
+		files[fmt.Sprintf("%c/%v", 'a'+side-1, name)] = fmt.Sprint(r.Intn(2))
+	}
+	writeFiles(t, dir, files)
+
+	want := make(map[string]bool)
+	for side := 1; side <= 2; side++ {
+		for name := range names[side] {
+			if !names[3-side][name] {
+				want[fmt.Sprintf("%v %v %v", ONLY_IN, side, name)] = true
+			} else if files["a/"+name] != files["b/"+name] {
+				want[fmt.Sprintf("%v %v", FILES_DIFFER, name)] = true
+			}
+		}
+	}
+
+	for _, batch := range []int{DIR_BATCH_SIZE, 1, 7, 64} {
+		diffs := diffDirsBatched(t, filepath.Join(dir, "a"), filepath.Join(dir, "b"), Options{Sequential: true}, batch)
+		got := make(map[string]bool)
+		for _, d := range diffs {
+			switch d.Type {
+			case ONLY_IN:
+				got[fmt.Sprintf("%v %v %v", d.Type, d.Side, d.Name)] = true
+			default:
+				got[fmt.Sprintf("%v %v", d.Type, filepath.Base(d.Path1))] = true
+			}
+		}
+		if len(got) != len(diffs) {
+			t.Errorf("batch %v: got %v differences, of which %v distinct", batch, len(diffs), len(got))
+		}
+		for key := range want {
+			if !got[key] {
+				t.Errorf("batch %v: missing %v", batch, key)
+			}
+		}
+		for key := range got {
+			if !want[key] {
+				t.Errorf("batch %v: unexpected %v", batch, key)
+			}
+		}
+	}
+}
+
+// summarize returns the types and paths of differences, one per line.
+func summarize(diffs []Difference) string {
+	var sb strings.Builder
+	for _, d := range diffs {
+		fmt.Fprintln(&sb, d.Type, d.Path1, d.Path2, d.Dir, d.Name, d.Side, d.Entries)
+	}
+	return sb.String()
+}
+
+// TestBatchedListing checks that directories read back from sorted batches are compared the same way as those held in
+// memory, with the options which change how entries are filtered, matched and counted.
+func TestBatchedListing(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a/.gitignore": "*.log\n", "a/x.log": "1", "b/x.log": "2", "a/Same": "1", "b/same": "1", "a/Case": "1",
+		"b/case": "2", "a/CASE": "1", "a/sub/f": "1", "b/sub/f": "2", "a/only": "1", "b/.hidden": "1",
+		"a/empty/f": "1", "b/empty/.keep": "",
+	})
+	if err := os.Remove(filepath.Join(dir, "b/empty/.keep")); err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []Options{
+		{},
+		{IgnoreCase: true},
+		{Gitignore: true, Plan: true},
+		{NoHidden: true, Exclude: []string{"only"}},
+		{Recursive: true},
+		{Recursive: true, Patch: true},
+	} {
+		opts.Sequential = true
+		var want []Difference
+		var wantExamined map[string]Examined
+		for _, batch := range []int{DIR_BATCH_SIZE, 1, 2, 3} {
+			opts.Stats = &Stats{}
+			diffs := diffDirsBatched(t, filepath.Join(dir, "a"), filepath.Join(dir, "b"), opts, batch)
+			examined := opts.Stats.Examined()
+			if batch == DIR_BATCH_SIZE {
+				want, wantExamined = diffs, examined
+				continue
+			}
+			if got, want := summarize(diffs), summarize(want); got != want {
+				t.Errorf("%+v, batch %v: got\n%v\nwant\n%v", opts, batch, got, want)
+			}
+			if fmt.Sprint(examined) != fmt.Sprint(wantExamined) {
+				t.Errorf("%+v, batch %v: got %v examined, want %v", opts, batch, examined, wantExamined)
+			}
+		}
+	}
+}
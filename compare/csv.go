@@ -0,0 +1,234 @@
+package compare
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Maximum number of rows listed as added, removed or changed in a CSV comparison, so that files which differ
+// throughout do not produce huge output.
+const CSV_MAX_ROWS = 100
+
+// CSVDiff describes how two CSV files which differ by their data differ.
+type CSVDiff struct {
+	// Columns only present in the header of the first or second file. Rows are compared by the columns common to both.
+	Columns1 []string `json:"columns1,omitempty"`
+	Columns2 []string `json:"columns2,omitempty"`
+	// Rows only present in the first file, removed, or only in the second file, added. Rows are identified by the
+	// values of their key columns, such as id=3, or by all their values in order of the names of their columns if there
+	// are no key columns.
+	Removed []string `json:"removed,omitempty"`
+	Added   []string `json:"added,omitempty"`
+	// Rows present in both files whose other values differ.
+	Changed []CSVChange `json:"changed,omitempty"`
+	// Whether only the first CSV_MAX_ROWS rows of some of the lists above are included.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// CSVChange is a row of two CSV files with the same key but different values, along with the columns which differ.
+// Columns is empty if several rows have the key in either file.
+type CSVChange struct {
+	Key     string   `json:"key"`
+	Columns []string `json:"columns,omitempty"`
+}
+
+// isCSV returns whether a file is a CSV file by its extension.
+func isCSV(file string) bool {
+	return strings.EqualFold(path.Ext(file), ".csv")
+}
+
+// table is a decoded CSV file, its rows indexed by column name.
+type table struct {
+	header  []string
+	columns map[string]int
+	rows    [][]string
+}
+
+// decodeCSV decodes a CSV file whose first record is its header. Rows may not have more values than the header.
+func decodeCSV(data []byte) (*table, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no header")
+	}
+
+	t := &table{header: records[0], columns: make(map[string]int, len(records[0])), rows: records[1:]}
+	for i, col := range t.header {
+		if _, ok := t.columns[col]; ok {
+			return nil, fmt.Errorf("duplicate column %q", col)
+		}
+		t.columns[col] = i
+	}
+	for i, row := range t.rows {
+		if len(row) > len(t.header) {
+			return nil, fmt.Errorf("record on line %v: more fields than the header", i+2)
+		}
+	}
+	return t, nil
+}
+
+// value returns the value of a row in a column, which is empty if the row is short.
+func (t *table) value(row []string, col string) string {
+	if i := t.columns[col]; i < len(row) {
+		return row[i]
+	}
+	return ""
+}
+
+// cmpCSV compares two CSV files by their data, so that the order of their columns and rows does not matter. Rows are
+// matched by the values of the CSVKeys columns, or by all their values if none are given. It returns nil if the files
+// hold the same data. ok is false if either file cannot be decoded or lacks a key column, which is reported as
+// INVALID_CSV, and the files should be compared byte for byte instead.
+func (c *comparer) cmpCSV(file1 string, file2 string) (diff *CSVDiff, ok bool, err error) {
+	var tables [2]*table
+	for i, file := range []string{file1, file2} {
+		data, err := c.readFile(i+1, file)
+		if err != nil {
+			return nil, false, err
+		}
+		c.read(int64(len(data)))
+		if tables[i], err = decodeCSV(data); err == nil {
+			for _, key := range c.opts.CSVKeys {
+				if _, ok := tables[i].columns[key]; !ok {
+					err = fmt.Errorf("no key column %q", key)
+				}
+			}
+		}
+		if err != nil {
+			c.report(Difference{Type: INVALID_CSV, Path1: file, Error: err.Error()})
+			return nil, false, nil
+		}
+	}
+
+	// Rows are compared by their values in the columns common to both files other than the key columns, in order of the
+	// names of the columns.
+	d := &CSVDiff{}
+	keys := make(map[string]bool, len(c.opts.CSVKeys))
+	for _, key := range c.opts.CSVKeys {
+		keys[key] = true
+	}
+	var common []string
+	for _, col := range tables[0].header {
+		if _, ok := tables[1].columns[col]; !ok {
+			d.Columns1 = append(d.Columns1, col)
+		} else if !keys[col] {
+			common = append(common, col)
+		}
+	}
+	for _, col := range tables[1].header {
+		if _, ok := tables[0].columns[col]; !ok {
+			d.Columns2 = append(d.Columns2, col)
+		}
+	}
+	sort.Strings(common)
+
+	// Group the rows of each file by their keys, along with their other values.
+	var groups [2]map[string][][]string
+	for i, t := range tables {
+		groups[i] = make(map[string][][]string)
+		for _, row := range t.rows {
+			values := make([]string, len(common))
+			for j, col := range common {
+				values[j] = t.value(row, col)
+			}
+			key := rowKey(t, row, c.opts.CSVKeys, values)
+			groups[i][key] = append(groups[i][key], values)
+		}
+	}
+
+	all := make(map[string]bool, len(groups[0])+len(groups[1]))
+	for _, g := range groups {
+		for key := range g {
+			all[key] = true
+		}
+	}
+	for _, key := range sortedKeys(all) {
+		rows1, rows2 := groups[0][key], groups[1][key]
+		switch {
+		case len(c.opts.CSVKeys) == 0:
+			// Without keys equal rows have equal keys, so only their numbers can differ.
+			for n := len(rows1); n > len(rows2); n-- {
+				d.Removed = append(d.Removed, key)
+			}
+			for n := len(rows2); n > len(rows1); n-- {
+				d.Added = append(d.Added, key)
+			}
+		case len(rows2) == 0:
+			d.Removed = append(d.Removed, key)
+		case len(rows1) == 0:
+			d.Added = append(d.Added, key)
+		case len(rows1) == 1 && len(rows2) == 1:
+			var cols []string
+			for j, col := range common {
+				if rows1[0][j] != rows2[0][j] {
+					cols = append(cols, col)
+				}
+			}
+			if len(cols) > 0 {
+				d.Changed = append(d.Changed, CSVChange{Key: key, Columns: cols})
+			}
+		case !sameRows(rows1, rows2):
+			d.Changed = append(d.Changed, CSVChange{Key: key})
+		}
+	}
+
+	if len(d.Columns1)+len(d.Columns2)+len(d.Removed)+len(d.Added)+len(d.Changed) == 0 {
+		return nil, true, nil
+	}
+	if len(d.Removed) > CSV_MAX_ROWS || len(d.Added) > CSV_MAX_ROWS || len(d.Changed) > CSV_MAX_ROWS {
+		d.Removed = d.Removed[:min(len(d.Removed), CSV_MAX_ROWS)]
+		d.Added = d.Added[:min(len(d.Added), CSV_MAX_ROWS)]
+		d.Changed = d.Changed[:min(len(d.Changed), CSV_MAX_ROWS)]
+		d.Truncated = true
+	}
+	return d, true, nil
+}
+
+// rowKey returns the key identifying a row of a table, made of the values of the key columns such as id=3,region=eu,
+// or of its values in the compared columns if there are no key columns.
+func rowKey(t *table, row []string, keys []string, values []string) string {
+	if len(keys) == 0 {
+		return csvLine(values)
+	}
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key + "=" + t.value(row, key)
+	}
+	return csvLine(parts)
+}
+
+// csvLine returns values as a single line of CSV, quoting them where needed.
+func csvLine(values []string) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write(values)
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// sameRows returns whether two lists of rows hold the same rows in any order.
+func sameRows(rows1 [][]string, rows2 [][]string) bool {
+	if len(rows1) != len(rows2) {
+		return false
+	}
+	counts := make(map[string]int, len(rows1))
+	for _, row := range rows1 {
+		counts[csvLine(row)]++
+	}
+	for _, row := range rows2 {
+		counts[csvLine(row)]--
+		if counts[csvLine(row)] < 0 {
+			return false
+		}
+	}
+	return true
+}
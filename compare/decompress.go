@@ -0,0 +1,114 @@
+package compare
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// decompressors open the decompressed stream of a compressed file by the extension of the file.
+var decompressors = map[string]func(r io.Reader) (io.ReadCloser, error){
+	".gz": func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	".bz2": func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	},
+	".zst": func(r io.Reader) (io.ReadCloser, error) {
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	},
+}
+
+// isCompressed returns whether a file is compressed by its extension, one of .gz, .bz2 or .zst.
+func isCompressed(file string) bool {
+	return decompressors[strings.ToLower(path.Ext(file))] != nil
+}
+
+// decompressedName returns the name of a file without its compression extension, if it has one, such as config.json
+// for config.json.gz.
+func decompressedName(name string) string {
+	if isCompressed(name) {
+		return strings.TrimSuffix(name, path.Ext(name))
+	}
+	return name
+}
+
+// openDecompressed opens a file on the given side, decompressing it as it is read if it is compressed. Corrupt data is
+// reported as an error reading the file, so that only that file is skipped.
+func (c *comparer) openDecompressed(side int, file string) (io.ReadCloser, error) {
+	f, err := c.open(side, file)
+	if err != nil {
+		return nil, err
+	}
+	decompress := decompressors[strings.ToLower(path.Ext(file))]
+	if decompress == nil {
+		return f, nil
+	}
+	r, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, readError(file, err)
+	}
+	return decompressed{r, f, file}, nil
+}
+
+// decompressed is the decompressed stream of an open file.
+type decompressed struct {
+	r    io.ReadCloser
+	f    io.Closer
+	name string
+}
+
+func (d decompressed) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = readError(d.name, err)
+	}
+	return n, err
+}
+
+func (d decompressed) Close() error {
+	d.r.Close()
+	return d.f.Close()
+}
+
+// readError returns an error decompressing a file as an error reading it, unless it already is one.
+func readError(file string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return err
+	}
+	return &fs.PathError{Op: "read", Path: file, Err: err}
+}
+
+// cmpDecompressed compares two files, either of which is compressed, by their decompressed contents, streaming them
+// since their decompressed sizes are not known up front. Differences are marked as Decompressed, with the offset of
+// the first differing byte counted in the decompressed contents.
+func cmpDecompressed(c *comparer, file1 string, file2 string) (*Difference, bool, error) {
+	r1, err := c.openDecompressed(1, file1)
+	if err != nil {
+		return nil, true, err
+	}
+	defer r1.Close()
+	r2, err := c.openDecompressed(2, file2)
+	if err != nil {
+		return nil, true, err
+	}
+	defer r2.Close()
+
+	eq, offset, err := c.cmpReaders(r1, r2)
+	if err != nil || eq {
+		return nil, true, err
+	}
+	return &Difference{Decompressed: true, Offset: &offset}, true, nil
+}
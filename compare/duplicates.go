@@ -0,0 +1,58 @@
+package compare
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+)
+
+// findDuplicates hashes every file below both directories once they have been compared, if FindDuplicates is set, and
+// reports each group of files with identical contents as DUPLICATES. Groups made of a single file in each directory at
+// the same relative path are left out, as they are just files which have not changed, as are empty files. It must not
+// be called while other work is outstanding.
+func (c *comparer) findDuplicates(dir1 string, dir2 string) {
+	if !c.opts.FindDuplicates || c.opts.Brief || c.opts.Plan || c.opts.StatOnly || c.stopped() {
+		return
+	}
+
+	// The sides are hashed one after the other.
+	var digests [2]map[string]string
+	for i, dir := range []string{dir1, dir2} {
+		digests[i] = make(map[string]string)
+		c.wg.Add(1)
+		c.spawn(func() { c.hashDir(i+1, dir, "", digests[i], nil) })
+		c.wg.Wait()
+		if c.stopped() {
+			return
+		}
+	}
+
+	empty := sha256.Sum256(nil)
+	groups := make(map[string][]string)
+	rels := make(map[string][2]string)
+	for i, dir := range []string{dir1, dir2} {
+		for rel, digest := range digests[i] {
+			if digest == hex.EncodeToString(empty[:]) {
+				continue
+			}
+			groups[digest] = append(groups[digest], c.join(i+1, dir, rel))
+			r := rels[digest]
+			r[i] = rel
+			rels[digest] = r
+		}
+	}
+
+	var found [][]string
+	for digest, files := range groups {
+		same := len(files) == 2 && rels[digest][0] == rels[digest][1] && rels[digest][0] != ""
+		if len(files) < 2 || same {
+			continue
+		}
+		sort.Strings(files)
+		found = append(found, files)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i][0] < found[j][0] })
+	for _, files := range found {
+		c.report(Difference{Type: DUPLICATES, Duplicates: files})
+	}
+}
@@ -0,0 +1,190 @@
+package compare
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// filesystem gives access to the files of one side of a comparison. Names are the paths as reported in differences,
+// so that local and remote paths can be handled by the same code.
+type filesystem interface {
+	Open(name string) (file, error)
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	// OpenDir opens a directory to read its entries a batch at a time, in no particular order.
+	OpenDir(name string) (dirFile, error)
+	Readlink(name string) (string, error)
+	// RealPath returns the path with all symlinks resolved, identifying the file it leads to.
+	RealPath(name string) (string, error)
+}
+
+// file is an open file of a filesystem.
+type file interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+	Stat() (fs.FileInfo, error)
+}
+
+// dirFile is an open directory of a filesystem.
+type dirFile interface {
+	ReadDir(n int) ([]fs.DirEntry, error)
+	io.Closer
+}
+
+// listedDir is a directory whose entries have all been read already, handed out a batch at a time.
+type listedDir struct {
+	entries []fs.DirEntry
+}
+
+func (d *listedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	batch := d.entries[:min(n, len(d.entries))]
+	d.entries = d.entries[len(batch):]
+	return batch, nil
+}
+
+func (d *listedDir) Close() error { return nil }
+
+// osFS is the filesystem of the local machine.
+type osFS struct{}
+
+func (osFS) Open(name string) (file, error) {
+	// Return a nil interface rather than a nil *os.File on error.
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (osFS) Lstat(name string) (fs.FileInfo, error)     { return os.Lstat(name) }
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (osFS) Readlink(name string) (string, error)       { return os.Readlink(name) }
+func (osFS) RealPath(name string) (string, error)       { return filepath.EvalSymlinks(name) }
+
+func (osFS) OpenDir(name string) (dirFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ioFS is a filesystem given as an fs.FS. Such filesystems have no symlinks, so Lstat is the same as Stat and no file
+// is a symlink.
+type ioFS struct {
+	fsys fs.FS
+}
+
+func (i ioFS) Open(name string) (file, error) {
+	f, err := i.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioFile{f}, nil
+}
+
+func (i ioFS) Stat(name string) (fs.FileInfo, error)      { return fs.Stat(i.fsys, name) }
+func (i ioFS) Lstat(name string) (fs.FileInfo, error)     { return fs.Stat(i.fsys, name) }
+func (i ioFS) ReadDir(name string) ([]fs.DirEntry, error) { return fs.ReadDir(i.fsys, name) }
+
+// OpenDir reads the whole directory at once if it cannot be read a batch at a time.
+func (i ioFS) OpenDir(name string) (dirFile, error) {
+	f, err := i.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := f.(fs.ReadDirFile); ok {
+		return d, nil
+	}
+	f.Close()
+	entries, err := fs.ReadDir(i.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return &listedDir{entries}, nil
+}
+
+func (i ioFS) Readlink(name string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+}
+
+func (i ioFS) RealPath(name string) (string, error) {
+	if _, err := fs.Stat(i.fsys, name); err != nil {
+		return "", err
+	}
+	return path.Clean(name), nil
+}
+
+// ioFile is an open file of an fs.FS. Reading at an offset is only supported if the file implements io.ReaderAt.
+type ioFile struct {
+	fs.File
+}
+
+func (f ioFile) ReadAt(p []byte, off int64) (int, error) {
+	if r, ok := f.File.(io.ReaderAt); ok {
+		return r.ReadAt(p, off)
+	}
+	return 0, errors.ErrUnsupported
+}
+
+// fs returns the filesystem a path on the given side, 1 or 2, is on. This is the filesystem given in the options for
+// that side if any, the remote host of a remote path or the local filesystem otherwise.
+func (c *comparer) fs(side int, name string) filesystem {
+	if f := c.fsys[side-1]; f != nil {
+		return f
+	}
+	if prefix, _, ok := splitRemote(name); ok {
+		if r, ok := c.remotes[prefix]; ok {
+			return r
+		}
+	}
+	return osFS{}
+}
+
+// open opens a file on the filesystem it is on.
+func (c *comparer) open(side int, name string) (file, error) {
+	return c.fs(side, name).Open(name)
+}
+
+// join joins a path on the given side with a slash separated path relative to it. Local paths are joined with the
+// separator of the operating system, so that paths on Windows use backslashes, while remote paths and those on other
+// filesystems keep using forward slashes.
+func (c *comparer) join(side int, name string, rel string) string {
+	if _, ok := c.fs(side, name).(osFS); ok {
+		return filepath.Join(name, rel)
+	}
+	return path.Join(name, rel)
+}
+
+// split splits a path on the given side into its directory and its last element, the reverse of join.
+func (c *comparer) split(side int, name string) (dir string, base string) {
+	if _, ok := c.fs(side, name).(osFS); ok {
+		return filepath.Dir(name), filepath.Base(name)
+	}
+	return path.Dir(name), path.Base(name)
+}
+
+// stat returns the info of a file, following symlinks, on the filesystem it is on.
+func (c *comparer) stat(side int, name string) (fs.FileInfo, error) {
+	return c.fs(side, name).Stat(name)
+}
+
+// readFile reads a whole file on the filesystem it is on.
+func (c *comparer) readFile(side int, name string) ([]byte, error) {
+	f, err := c.open(side, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
@@ -0,0 +1,136 @@
+package compare
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Name of the files holding the patterns of entries left out with Gitignore.
+const GITIGNORE = ".gitignore"
+
+// gitignore holds the patterns of the .gitignore file of a directory along with those of the directories above it,
+// which together apply to the entries of the directory.
+type gitignore struct {
+	parent *gitignore
+	// Path of the directory relative to the compared directory, which patterns containing a slash are relative to.
+	rel      string
+	patterns []ignorePattern
+}
+
+// ignorePattern is a single pattern of a .gitignore file, split into its slash separated segments. A segment of **
+// matches any number of directories.
+type ignorePattern struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// parseGitignore parses the contents of the .gitignore file of the directory at the given relative path, following
+// the syntax used by git.
+func parseGitignore(data []byte, rel string, parent *gitignore) *gitignore {
+	g := &gitignore{parent: parent, rel: rel}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var p ignorePattern
+		if line[0] == '!' {
+			p.negate, line = true, line[1:]
+		} else if line[0] == '\\' {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		// A pattern with a slash other than at its end is relative to the directory, any other matches at any depth.
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if !anchored {
+			p.segments = append(p.segments, "**")
+		}
+		for _, s := range strings.Split(line, "/") {
+			p.segments = append(p.segments, strings.ReplaceAll(s, "[!", "[^"))
+		}
+		g.patterns = append(g.patterns, p)
+	}
+	return g
+}
+
+// ignored returns whether the entry at the given path relative to the compared directory is ignored. As in git, later
+// patterns take precedence over earlier ones, and patterns of deeper directories over those above them.
+func (g *gitignore) ignored(rel string, dir bool) bool {
+	for ; g != nil; g = g.parent {
+		segments := strings.Split(relPath(g.rel, rel), "/")
+		for i := len(g.patterns) - 1; i >= 0; i-- {
+			p := g.patterns[i]
+			if (!p.dirOnly || dir) && matchSegments(p.segments, segments) {
+				return !p.negate
+			}
+		}
+	}
+	return false
+}
+
+// matchSegments returns whether the segments of a path match the segments of a pattern. A trailing ** matches
+// everything inside a directory but not the directory itself.
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		if len(pattern) == 1 {
+			return len(segments) > 0
+		}
+		for i := range segments {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], segments[0])
+	return ok && matchSegments(pattern[1:], segments[1:])
+}
+
+// gitignore returns the patterns which apply to the entries of the directory dir on the given side, at the given
+// relative path, loading its .gitignore file if it has one. They are kept so that its subdirectories can build on them.
+func (c *comparer) gitignore(side int, dir string, rel string, entries []fs.DirEntry) *gitignore {
+	c.mu.Lock()
+	parent := c.ignores[side-1][path.Dir(rel)]
+	c.mu.Unlock()
+	if rel == "" {
+		parent = nil
+	}
+
+	g := parent
+	for _, e := range entries {
+		if e.Name() != GITIGNORE || !e.Type().IsRegular() {
+			continue
+		}
+		data, err := c.readFile(side, c.join(side, dir, GITIGNORE))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			c.fail(err)
+		} else if err == nil {
+			g = parseGitignore(data, rel, parent)
+		}
+	}
+
+	c.mu.Lock()
+	c.ignores[side-1][path.Clean(rel)] = g
+	c.mu.Unlock()
+	return g
+}
@@ -0,0 +1,77 @@
+package compare
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// Hash algorithms which can be used to compare files.
+const (
+	SHA256 = "sha256"
+	MD5    = "md5"
+	CRC32  = "crc32"
+)
+
+// newHash returns a new hash for the given algorithm.
+func newHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case SHA256:
+		return sha256.New(), nil
+	case MD5:
+		return md5.New(), nil
+	case CRC32:
+		return crc32.NewIEEE(), nil
+	}
+	return nil, fmt.Errorf("unknown hash algorithm: %v", algo)
+}
+
+// hashFile returns the digest of a file's contents. The file is streamed through the hash rather than read whole, and
+// hashing stops early if the comparison is stopped, in which case the digest will not be used anyway.
+func (c *comparer) hashFile(side int, file string, algo string) ([]byte, error) {
+	h, err := newHash(algo)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := c.open(side, file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, &stopReader{c, f}); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// stopReader reads from a reader until it ends or the comparison is stopped, whichever comes first.
+type stopReader struct {
+	c *comparer
+	r io.Reader
+}
+
+func (s *stopReader) Read(p []byte) (int, error) {
+	if s.c.stopped() {
+		return 0, io.EOF
+	}
+	return s.r.Read(p)
+}
+
+// cmpHashes compares two files by their digests and returns whether they are equal or not.
+func (c *comparer) cmpHashes(file1 string, file2 string, algo string) (bool, error) {
+	h1, err := c.hashFile(1, file1, algo)
+	if err != nil {
+		return false, err
+	}
+	h2, err := c.hashFile(2, file2, algo)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(h1, h2), nil
+}
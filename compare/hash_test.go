@@ -0,0 +1,23 @@
+package compare
+
+import (
+	"context"
+	"testing"
+)
+
+// BenchmarkHashFile hashes a file of 16 MiB with each of the hash algorithms.
+func BenchmarkHashFile(b *testing.B) {
+	const size = 16 * 1024 * 1024
+	file, _ := largeFiles(b, size)
+	for _, algo := range []string{SHA256, MD5, CRC32} {
+		b.Run(algo, func(b *testing.B) {
+			c := newComparer(context.Background(), Options{})
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if _, err := c.hashFile(1, file, algo); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package compare
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math"
+)
+
+// Number of bytes shown in each row of a hex dump.
+const HEX_ROW_SIZE = 16
+
+// Number of equal rows shown before and after each differing row of a hex dump.
+const HEX_CONTEXT = 2
+
+// Maximum number of rows of a hex dump, so that files which differ throughout do not produce huge output.
+const HEX_MAX_ROWS = 32
+
+// HexRow is a row of a hex dump of two files which differ, with the hex encoded bytes of each file starting at Offset.
+// A row holds HEX_ROW_SIZE bytes, or fewer once a file has ended.
+type HexRow struct {
+	Offset int64  `json:"offset"`
+	Hex1   string `json:"hex1"`
+	Hex2   string `json:"hex2"`
+}
+
+// hexDump returns the rows of a hex dump of two files around the regions where they differ, starting a few rows before
+// offset, the first differing byte. Only rows which differ and HEX_CONTEXT rows around them are included, up to
+// HEX_MAX_ROWS rows. truncated is true if the dump stopped there before the end of the files.
+func (c *comparer) hexDump(file1 string, file2 string, offset int64) (rows []HexRow, truncated bool, err error) {
+	start := max(offset/HEX_ROW_SIZE-HEX_CONTEXT, 0) * HEX_ROW_SIZE
+	var readers [2]io.Reader
+	for i, file := range []string{file1, file2} {
+		f, err := c.open(i+1, file)
+		if err != nil {
+			return nil, false, err
+		}
+		defer f.Close()
+		readers[i] = bufio.NewReaderSize(io.NewSectionReader(f, start, math.MaxInt64-start), BUFFER_SIZE)
+	}
+
+	// Equal rows are held back until it is known whether they are followed by a differing row.
+	var pending []HexRow
+	after := 0
+	b1 := make([]byte, HEX_ROW_SIZE)
+	b2 := make([]byte, HEX_ROW_SIZE)
+	for pos := start; !c.stopped(); pos += HEX_ROW_SIZE {
+		n1, err1 := io.ReadFull(readers[0], b1)
+		n2, err2 := io.ReadFull(readers[1], b2)
+		c.read(int64(n1 + n2))
+		if err1 != nil && err1 != io.EOF && err1 != io.ErrUnexpectedEOF {
+			return nil, false, err1
+		}
+		if err2 != nil && err2 != io.EOF && err2 != io.ErrUnexpectedEOF {
+			return nil, false, err2
+		}
+		if n1 == 0 && n2 == 0 {
+			break
+		}
+		if len(rows) >= HEX_MAX_ROWS {
+			return rows, true, nil
+		}
+
+		row := HexRow{Offset: pos, Hex1: hex.EncodeToString(b1[:n1]), Hex2: hex.EncodeToString(b2[:n2])}
+		if !bytes.Equal(b1[:n1], b2[:n2]) {
+			rows = append(rows, pending...)
+			pending = pending[:0]
+			rows = append(rows, row)
+			after = HEX_CONTEXT
+		} else if after > 0 {
+			rows = append(rows, row)
+			after--
+		} else {
+			pending = append(pending, row)
+			if len(pending) > HEX_CONTEXT {
+				pending = pending[1:]
+			}
+		}
+	}
+	return rows, false, nil
+}
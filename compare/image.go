@@ -0,0 +1,94 @@
+package compare
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// ImageDiff summarizes how two images which differ differ pixel by pixel. Pixels are only compared if both images have
+// the same dimensions.
+type ImageDiff struct {
+	Width1  int `json:"width1"`
+	Height1 int `json:"height1"`
+	Width2  int `json:"width2"`
+	Height2 int `json:"height2"`
+	// Number and percentage of the pixels which differ.
+	Pixels  int     `json:"pixels"`
+	Percent float64 `json:"percent"`
+	// Bounding box of the pixels which differ, from Left and Top up to but excluding Right and Bottom. Empty if no pixels
+	// differ, as happens with images encoded differently.
+	Left   int `json:"left"`
+	Top    int `json:"top"`
+	Right  int `json:"right"`
+	Bottom int `json:"bottom"`
+}
+
+// isImage returns whether a file is a PNG or JPEG image by its extension.
+func isImage(file string) bool {
+	switch strings.ToLower(path.Ext(file)) {
+	case ".png", ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
+
+// decodeImage decodes the image in a file on the given side.
+func (c *comparer) decodeImage(side int, file string) (image.Image, error) {
+	f, err := c.open(side, file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(bufio.NewReaderSize(f, BUFFER_SIZE))
+	return img, err
+}
+
+// diffImages decodes two images which differ and compares them pixel by pixel. If either file cannot be decoded it is
+// reported as INVALID_IMAGE and nil is returned.
+func (c *comparer) diffImages(file1 string, file2 string) (*ImageDiff, error) {
+	var imgs [2]image.Image
+	for i, file := range []string{file1, file2} {
+		// Errors reading the files are returned, any other error comes from decoding them.
+		img, err := c.decodeImage(i+1, file)
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			return nil, err
+		}
+		if err != nil {
+			c.report(Difference{Type: INVALID_IMAGE, Path1: file, Error: err.Error()})
+			return nil, nil
+		}
+		imgs[i] = img
+	}
+
+	b1, b2 := imgs[0].Bounds(), imgs[1].Bounds()
+	d := &ImageDiff{Width1: b1.Dx(), Height1: b1.Dy(), Width2: b2.Dx(), Height2: b2.Dy()}
+	if b1.Size() != b2.Size() {
+		return d, nil
+	}
+
+	// Pixels are compared by their colors at full precision, so that images encoded differently may still be equal.
+	box := image.Rectangle{}
+	for y := 0; y < b1.Dy() && !c.stopped(); y++ {
+		for x := 0; x < b1.Dx(); x++ {
+			r1, g1, bl1, a1 := imgs[0].At(b1.Min.X+x, b1.Min.Y+y).RGBA()
+			r2, g2, bl2, a2 := imgs[1].At(b2.Min.X+x, b2.Min.Y+y).RGBA()
+			if r1 != r2 || g1 != g2 || bl1 != bl2 || a1 != a2 {
+				d.Pixels++
+				box = box.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if total := b1.Dx() * b1.Dy(); total > 0 {
+		d.Percent = float64(d.Pixels) * 100 / float64(total)
+	}
+	d.Left, d.Top, d.Right, d.Bottom = box.Min.X, box.Min.Y, box.Max.X, box.Max.Y
+	return d, nil
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package compare
+
+import "os"
+
+// sameInode is not supported on this platform, so files are always compared by their contents.
+func sameInode(stat1 os.FileInfo, stat2 os.FileInfo) bool {
+	return false
+}
+
+// Whether the devices of files can be told apart on this platform.
+const deviceSupported = false
+
+// device is not supported on this platform, so the device is never known.
+func device(stat os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// deviceNumber is not supported on this platform, so device files are only compared by their type.
+func deviceNumber(stat os.FileInfo) (string, bool) {
+	return "", false
+}
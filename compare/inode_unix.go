@@ -0,0 +1,45 @@
+//go:build unix
+
+package compare
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// sameInode returns whether two files are the same inode on the same device, such as hardlinks to each other, in which
+// case their contents are necessarily equal.
+func sameInode(stat1 os.FileInfo, stat2 os.FileInfo) bool {
+	s1, ok1 := stat1.Sys().(*syscall.Stat_t)
+	s2, ok2 := stat2.Sys().(*syscall.Stat_t)
+	if !ok1 || !ok2 {
+		return false
+	}
+
+	return s1.Dev == s2.Dev && s1.Ino == s2.Ino
+}
+
+// Whether the devices of files can be told apart on this platform.
+const deviceSupported = true
+
+// device returns the id of the device a file is on. ok is false if it is not known.
+func device(stat os.FileInfo) (uint64, bool) {
+	s, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(s.Dev), true
+}
+
+// deviceNumber returns the number of the device a device file stands for as major:minor. ok is false if it is not
+// known.
+func deviceNumber(stat os.FileInfo) (string, bool) {
+	s, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%d:%d", unix.Major(uint64(s.Rdev)), unix.Minor(uint64(s.Rdev))), true
+}
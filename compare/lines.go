@@ -0,0 +1,132 @@
+package compare
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+// Maximum number of lines listed as only in either file in a comparison of sets of lines, so that files which differ
+// throughout do not produce huge output.
+const LINES_MAX = 100
+
+// LinesDiff lists the lines only present in either of two text files compared as sets of lines, in the order in which
+// they first appear in their files.
+type LinesDiff struct {
+	Lines1 []string `json:"lines1,omitempty"`
+	Lines2 []string `json:"lines2,omitempty"`
+	// Whether only the first LINES_MAX lines of either list are included.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// lineSet is the set of distinct lines of a file, each kept only by its digest so that memory does not grow with the
+// length of the lines. Each line is mapped to whether it has been found in the other file too.
+type lineSet map[[sha256.Size]byte]bool
+
+// eachLine calls fn with every line of the file on the given side, without its line ending, along with its digest. A
+// CR before the LF is part of the line unless IgnoreLineEndings is set. The file is rejected by returning
+// errBinary if it has a NUL byte within its first SNIFF_SIZE bytes.
+func (c *comparer) eachLine(side int, file string, fn func(line []byte, sum [sha256.Size]byte)) error {
+	f, err := c.open(side, file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, max(c.opts.BufferSize, SNIFF_SIZE))
+	if sniff, err := r.Peek(SNIFF_SIZE); err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return err
+	} else if isBinary(sniff) {
+		return errBinary
+	}
+	for !c.stopped() {
+		line, err := r.ReadBytes('\n')
+		c.read(int64(len(line)))
+		if len(line) > 0 {
+			line = bytes.TrimSuffix(line, []byte("\n"))
+			if c.opts.IgnoreLineEndings {
+				line = bytes.TrimSuffix(line, []byte("\r"))
+			}
+			fn(line, sha256.Sum256(line))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errBinary is returned by eachLine for binary files.
+var errBinary = errors.New("binary file")
+
+// cmpLines compares two text files as sets of lines, like comm on their sorted and deduplicated lines, so that the
+// order of lines and how often they appear do not matter. Only the digests of the lines of the first file are kept,
+// and both files are streamed, the first one twice if it has lines the second lacks. It returns nil if both files have
+// the same lines. ok is false if either file is binary, which is reported as BINARY_FILE, and the files should be
+// compared byte for byte instead.
+func (c *comparer) cmpLines(file1 string, file2 string) (diff *LinesDiff, ok bool, err error) {
+	d := &LinesDiff{}
+	set1 := lineSet{}
+	binary := func(file string, err error) (*LinesDiff, bool, error) {
+		if err == errBinary {
+			c.report(Difference{Type: BINARY_FILE, Path1: file, Error: err.Error()})
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	if err := c.eachLine(1, file1, func(line []byte, sum [sha256.Size]byte) { set1[sum] = false }); err != nil {
+		return binary(file1, err)
+	}
+
+	// Lines of the second file are marked as found in the set of the first. Only the digests of the lines listed as
+	// only in the second file are kept, to list each of them once, and there are at most LINES_MAX + 1 of them.
+	listed := lineSet{}
+	err = c.eachLine(2, file2, func(line []byte, sum [sha256.Size]byte) {
+		if _, ok := set1[sum]; ok {
+			set1[sum] = true
+		} else if !listed[sum] && len(d.Lines2) <= LINES_MAX {
+			d.Lines2 = append(d.Lines2, string(line))
+			listed[sum] = true
+		}
+	})
+	if err != nil {
+		return binary(file2, err)
+	}
+
+	// The lines only in the first file are found by reading it again, now that the lines of the second one are known.
+	// Lines listed are marked as found so that they are only listed once.
+	only1 := false
+	for _, found := range set1 {
+		if !found {
+			only1 = true
+			break
+		}
+	}
+	if only1 {
+		err = c.eachLine(1, file1, func(line []byte, sum [sha256.Size]byte) {
+			if !set1[sum] && len(d.Lines1) <= LINES_MAX {
+				d.Lines1 = append(d.Lines1, string(line))
+			}
+			set1[sum] = true
+		})
+		if err != nil {
+			return binary(file1, err)
+		}
+	}
+
+	if len(d.Lines1)+len(d.Lines2) == 0 {
+		return nil, true, nil
+	}
+	if len(d.Lines1) > LINES_MAX || len(d.Lines2) > LINES_MAX {
+		d.Lines1 = d.Lines1[:min(len(d.Lines1), LINES_MAX)]
+		d.Lines2 = d.Lines2[:min(len(d.Lines2), LINES_MAX)]
+		d.Truncated = true
+	}
+	return d, true, nil
+}
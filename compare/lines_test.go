@@ -0,0 +1,23 @@
+package compare
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestLinesSet checks that the lines only in either file are each listed once, in the order in which they first appear.
+func TestLinesSet(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "a\nb\nc\nb\nx\n", "b": "c\nd\na\nd\ne\n"})
+	opts := Options{LinesSet: true}
+	diffs, err := DiffFiles(context.Background(), filepath.Join(dir, "a"), filepath.Join(dir, "b"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &LinesDiff{Lines1: []string{"b", "x"}, Lines2: []string{"d", "e"}}
+	if len(diffs) != 1 || !reflect.DeepEqual(diffs[0].Lines, want) {
+		t.Errorf("got %+v, want lines %+v", diffs, want)
+	}
+}
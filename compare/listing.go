@@ -0,0 +1,303 @@
+package compare
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// DIR_BATCH_SIZE is the number of entries of a directory read at a time. The entries of a larger directory are sorted a
+// batch at a time into runs in a temporary file, which are merged while comparing it, so that the memory used for it
+// does not grow with its number of entries.
+const DIR_BATCH_SIZE = 65536
+
+// listing is the filtered entries of a directory on one side, read in order of their keys. The entries of a directory
+// which fits in a single batch are held in memory along with their keys as given by keys. Those of a larger directory
+// are read back from the runs of a temporary file, with their keys as given by key and ties broken by their names.
+type listing struct {
+	c    *comparer
+	side int
+	dir  string
+	// Number of entries, after filtering.
+	n int
+	// The entries held in memory and their keys, those not yet read.
+	entries []fs.DirEntry
+	keys    []string
+	// The temporary file holding the runs, its writer while being filled and its size.
+	temp *os.File
+	w    *bufio.Writer
+	size int64
+	// The runs not yet read to their end, and the offsets in the temporary file at which each run starts.
+	runs   runHeap
+	starts []int64
+	err    error
+}
+
+// list reads the entries of the directory dir on the given side at the relative path rel, a batch at a time, and
+// filters them.
+func (c *comparer) list(side int, dir string, rel string) (*listing, error) {
+	d, err := c.fs(side, dir).OpenDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	l := &listing{c: c, side: side, dir: dir}
+	var batch []fs.DirEntry
+	var ignore *gitignore
+	for {
+		entries, err := d.ReadDir(c.batch - len(batch))
+		batch = append(batch, entries...)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			l.close()
+			return nil, err
+		}
+		if len(batch) < c.batch {
+			continue
+		}
+
+		if l.temp == nil {
+			// The .gitignore file of the directory may be in any batch, so it is looked up by its name instead.
+			if c.opts.Gitignore {
+				var found []fs.DirEntry
+				if info, err := c.fs(side, dir).Lstat(c.join(side, dir, GITIGNORE)); err == nil {
+					found = append(found, fs.FileInfoToDirEntry(info))
+				}
+				ignore = c.gitignore(side, dir, rel, found)
+			}
+			if l.temp, err = os.CreateTemp("", "diff-*"); err != nil {
+				return nil, err
+			}
+			l.w = bufio.NewWriter(l.temp)
+		}
+		if err := l.spill(rel, batch, ignore); err != nil {
+			l.close()
+			return nil, err
+		}
+		batch = batch[:0]
+	}
+
+	if l.temp == nil {
+		// Entries are filtered in order of their names, like those listed by ReadDir.
+		sort.Slice(batch, func(i, j int) bool { return batch[i].Name() < batch[j].Name() })
+		l.entries = c.filter(side, dir, rel, batch)
+		l.keys = c.sortByKey(l.entries)
+		l.n = len(l.entries)
+		return l, nil
+	}
+	if err := l.spill(rel, batch, ignore); err != nil {
+		l.close()
+		return nil, err
+	}
+	if err := l.open(); err != nil {
+		l.close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// spill filters a batch of entries sorted by their names and writes them to a new run of the temporary file, in order
+// of their keys. Each entry is written as the length of its name, its name and its type, the lengths and types as
+// varints.
+func (l *listing) spill(rel string, batch []fs.DirEntry, ignore *gitignore) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	sort.Slice(batch, func(i, j int) bool { return batch[i].Name() < batch[j].Name() })
+	entries := l.c.filterIgnored(l.side, l.dir, rel, batch, ignore)
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = l.c.key(e)
+	}
+	sort.Stable(byKey{entries, keys})
+
+	l.starts = append(l.starts, l.size)
+	var buf []byte
+	for _, e := range entries {
+		buf = binary.AppendUvarint(buf[:0], uint64(len(e.Name())))
+		buf = append(buf, e.Name()...)
+		buf = binary.AppendUvarint(buf, uint64(e.Type()))
+		n, err := l.w.Write(buf)
+		l.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	l.n += len(entries)
+	return nil
+}
+
+// open flushes the temporary file and starts reading each of its runs.
+func (l *listing) open() error {
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	for i, start := range l.starts {
+		end := l.size
+		if i+1 < len(l.starts) {
+			end = l.starts[i+1]
+		}
+		r := &run{r: bufio.NewReader(io.NewSectionReader(l.temp, start, end-start))}
+		if ok, err := l.advance(r); err != nil {
+			return err
+		} else if ok {
+			l.runs = append(l.runs, r)
+		}
+	}
+	heap.Init(&l.runs)
+	return nil
+}
+
+// advance reads the next entry of a run, returning false at its end.
+func (l *listing) advance(r *run) (bool, error) {
+	n, err := binary.ReadUvarint(r.r)
+	if errors.Is(err, io.EOF) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	name := make([]byte, n)
+	if _, err := io.ReadFull(r.r, name); err != nil {
+		return false, err
+	}
+	typ, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return false, err
+	}
+	path := l.c.join(l.side, l.dir, string(name))
+	r.entry = spilledEntry{l.c.fs(l.side, path), path, string(name), fs.FileMode(typ)}
+	r.key = l.c.key(r.entry)
+	return true, nil
+}
+
+// peek returns the key of the next entries, or false if all have been read or reading them failed.
+func (l *listing) peek() (string, bool) {
+	if l.err != nil {
+		return "", false
+	}
+	if l.temp == nil {
+		if len(l.keys) == 0 {
+			return "", false
+		}
+		return l.keys[0], true
+	}
+	if len(l.runs) == 0 {
+		return "", false
+	}
+	return l.runs[0].key, true
+}
+
+// next reads the entries sharing the key given by peek.
+func (l *listing) next() []fs.DirEntry {
+	key, ok := l.peek()
+	if !ok {
+		return nil
+	}
+	var group []fs.DirEntry
+	if l.temp == nil {
+		for len(l.keys) > 0 && l.keys[0] == key {
+			group = append(group, l.entries[0])
+			l.entries, l.keys = l.entries[1:], l.keys[1:]
+		}
+		return group
+	}
+	for len(l.runs) > 0 && l.runs[0].key == key {
+		r := l.runs[0]
+		group = append(group, r.entry)
+		if ok, err := l.advance(r); err != nil {
+			l.err = err
+			return group
+		} else if ok {
+			heap.Fix(&l.runs, 0)
+		} else {
+			heap.Pop(&l.runs)
+		}
+	}
+	return group
+}
+
+// close removes the temporary file of the listing, if any.
+func (l *listing) close() {
+	if l.temp != nil {
+		l.temp.Close()
+		os.Remove(l.temp.Name())
+	}
+}
+
+// run is a run of the temporary file of a listing, along with its next entry and the key of that entry.
+type run struct {
+	r     *bufio.Reader
+	entry fs.DirEntry
+	key   string
+}
+
+// runHeap orders runs by the key and then the name of their next entries.
+type runHeap []*run
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].entry.Name() < h[j].entry.Name()
+}
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)   { *h = append(*h, x.(*run)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// spilledEntry is an entry read back from the temporary file of a listing. Its info is looked up again if asked for.
+type spilledEntry struct {
+	fsys filesystem
+	path string
+	name string
+	typ  fs.FileMode
+}
+
+func (e spilledEntry) Name() string               { return e.name }
+func (e spilledEntry) IsDir() bool                { return e.typ.IsDir() }
+func (e spilledEntry) Type() fs.FileMode          { return e.typ }
+func (e spilledEntry) Info() (fs.FileInfo, error) { return e.fsys.Lstat(e.path) }
+
+// match pairs up the entries of both directories sharing a key, as read by next from their listings. Entries sharing
+// their key with others on their side are matched by their names instead. The entries left over are only present on
+// their side.
+func match(key string, g1 []fs.DirEntry, g2 []fs.DirEntry) (pairs [][2]fs.DirEntry, only1, only2 []fs.DirEntry) {
+	name := func(g []fs.DirEntry, i int) string {
+		if len(g) > 1 {
+			return g[i].Name()
+		}
+		return key
+	}
+	paired := make([]bool, len(g2))
+	for i, f := range g1 {
+		found := false
+		for j, f2 := range g2 {
+			if !paired[j] && name(g1, i) == name(g2, j) {
+				paired[j], found = true, true
+				pairs = append(pairs, [2]fs.DirEntry{f, f2})
+				break
+			}
+		}
+		if !found {
+			only1 = append(only1, f)
+		}
+	}
+	for j, f := range g2 {
+		if !paired[j] {
+			only2 = append(only2, f)
+		}
+	}
+	return pairs, only1, only2
+}
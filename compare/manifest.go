@@ -0,0 +1,206 @@
+package compare
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Manifest hashes every file below dir with SHA-256 and returns the hex encoded digests keyed by the slash separated
+// paths of the files relative to dir. All subdirectories are recursed into, and entries skipped by Exclude or Include
+// are left out. Symlinks are followed if FollowSymlinks is set and left out otherwise. Files which cannot be read are
+// an error, as the manifest would be incomplete.
+func Manifest(ctx context.Context, dir string, opts Options) (map[string]string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	c := newComparer(ctx, opts)
+	if err := c.connect(dir); err != nil {
+		return nil, err
+	}
+	digests := make(map[string]string)
+	c.wg.Add(1)
+	c.spawn(func() { c.hashDir(1, dir, "", digests, nil) })
+	diffs, err := c.wait()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range diffs {
+		if d.Incomplete() {
+			return nil, fmt.Errorf("cannot hash %v: %v", d.Path1, d.Error)
+		}
+	}
+	return digests, nil
+}
+
+// DiffManifest compares the files below dir against a manifest of the digests they are expected to have, as returned
+// by Manifest or ReadManifest, without needing the tree the manifest was made from. The files are found and hashed as
+// by Manifest. Files only in the manifest are reported as ONLY_IN on side 1, in a directory below name, the name of
+// the manifest, files only in dir as ONLY_IN on side 2 and files whose digests differ as FILES_DIFFER with Path1 below
+// name. Files which cannot be read are reported as usual. If ctx is done before the comparison finishes its error is
+// returned.
+func DiffManifest(
+	ctx context.Context, name string, manifest map[string]string, dir string, opts Options,
+) ([]Difference, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	c := newComparer(ctx, opts)
+	if err := c.connect("", dir); err != nil {
+		return nil, err
+	}
+	digests := make(map[string]string)
+	c.wg.Add(1)
+	c.spawn(func() { c.hashDir(2, dir, "", digests, nil) })
+	c.wg.Wait()
+
+	// Files which could not be hashed, or are below directories which could not be read, are not missing.
+	unread := make(map[string]bool)
+	for _, d := range c.diffs {
+		if d.Incomplete() {
+			unread[relPath(dir, d.Path1)] = true
+		}
+	}
+
+	all := make(map[string]bool, len(manifest)+len(digests))
+	for rel := range manifest {
+		all[rel] = true
+	}
+	for rel := range digests {
+		all[rel] = true
+	}
+	for _, rel := range sortedKeys(all) {
+		if c.stopped() {
+			break
+		}
+		want, ok1 := manifest[rel]
+		got, ok2 := digests[rel]
+		switch {
+		case !ok2 && (unread[rel] || touched(rel, unread)):
+		case !ok2:
+			c.report(Difference{Type: ONLY_IN, Dir: path.Join(name, path.Dir(rel)), Name: path.Base(rel), Side: 1})
+		case !ok1:
+			c.report(Difference{Type: ONLY_IN, Dir: c.join(2, dir, path.Dir(rel)), Name: path.Base(rel), Side: 2})
+		case want != got:
+			c.report(Difference{Type: FILES_DIFFER, Path1: path.Join(name, rel), Path2: c.join(2, dir, rel)})
+		case c.opts.ReportIdentical:
+			c.report(Difference{Type: IDENTICAL, Path1: path.Join(name, rel), Path2: c.join(2, dir, rel)})
+		}
+	}
+	return c.wait()
+}
+
+// ReadManifest reads a manifest, made of lines holding the hex encoded SHA-256 digest of a file followed by a space and
+// the slash separated path of the file. Empty lines are ignored.
+func ReadManifest(r io.Reader) (map[string]string, error) {
+	manifest := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		digest, rel, ok := strings.Cut(line, " ")
+		if _, err := hex.DecodeString(digest); !ok || err != nil || len(digest) != 64 || rel == "" {
+			return nil, fmt.Errorf("invalid manifest line %v: %q", n, line)
+		}
+		manifest[path.Clean(rel)] = strings.ToLower(digest)
+	}
+	return manifest, scanner.Err()
+}
+
+// WriteManifest writes a manifest in the format read by ReadManifest, with the files in order of their paths.
+func WriteManifest(w io.Writer, manifest map[string]string) error {
+	paths := make([]string, 0, len(manifest))
+	for rel := range manifest {
+		if strings.ContainsAny(rel, "\r\n") {
+			return fmt.Errorf("cannot write path with a line break to a manifest: %q", rel)
+		}
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	bw := bufio.NewWriter(w)
+	for _, rel := range paths {
+		fmt.Fprintf(bw, "%v %v\n", manifest[rel], rel)
+	}
+	return bw.Flush()
+}
+
+// hashDir hashes the files below the directory dir on the given side, at the given relative path, into digests keyed
+// by their relative paths, up being the ancestry of the directories above. Subdirectories and files are hashed
+// concurrently.
+func (c *comparer) hashDir(side int, dir string, rel string, digests map[string]string, up *ancestry) {
+	defer c.wg.Done()
+	if c.stopped() {
+		return
+	}
+
+	// Directories reached again through symlinks below themselves are skipped, to avoid looping forever.
+	real, err := c.fs(side, dir).RealPath(dir)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	if up.contains([2]string{real}) {
+		c.report(Difference{Type: SYMLINK_LOOP, Path1: dir})
+		return
+	}
+
+	dirs := &ancestry{key: [2]string{real}, parent: up}
+
+	if !c.acquire() {
+		return
+	}
+	entries, err := c.fs(side, dir).ReadDir(dir)
+	c.release()
+	if err != nil {
+		c.fail(err)
+		return
+	}
+
+	for _, e := range c.filter(side, dir, rel, entries) {
+		name, rel := c.join(side, dir, e.Name()), path.Join(rel, e.Name())
+		isDir := e.IsDir()
+		if isLink(e) {
+			if !c.follows(side) {
+				continue
+			}
+			var ok bool
+			if isDir, ok = c.resolve(side, dir, e); !ok {
+				continue
+			}
+		} else if !isDir && !e.Type().IsRegular() {
+			continue
+		}
+
+		c.wg.Add(1)
+		if isDir {
+			c.spawn(func() { c.hashDir(side, name, rel, digests, dirs) })
+			continue
+		}
+		c.spawn(func() {
+			defer c.wg.Done()
+			if !c.acquire() {
+				return
+			}
+			digest, err := c.hashFile(side, name, SHA256)
+			c.release()
+			c.compared()
+			if err != nil {
+				c.fail(err)
+				return
+			}
+			c.mu.Lock()
+			digests[rel] = hex.EncodeToString(digest)
+			c.mu.Unlock()
+		})
+	}
+}
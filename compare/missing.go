@@ -0,0 +1,34 @@
+package compare
+
+import "io/fs"
+
+// diffMissing reports everything in the compared path of the given side as only in it, as the compared path of the
+// other side does not exist. A directory has each of its entries reported, as if compared against an empty directory,
+// and a file is reported itself. Should be called via a goroutine.
+func (c *comparer) diffMissing(side int, name string, stat fs.FileInfo) {
+	defer c.wg.Done()
+	if !stat.IsDir() {
+		dir, base := c.split(side, name)
+		c.reportOnly(side, dir, base)
+		return
+	}
+
+	if !c.acquire() {
+		return
+	}
+	files, err := c.fs(side, name).ReadDir(name)
+	c.release()
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	files = c.filter(side, name, "", files)
+	if side == 1 {
+		c.examine("", name, "", files, nil)
+	} else {
+		c.examine("", "", name, nil, files)
+	}
+	for _, f := range files {
+		c.reportOnly(side, name, f.Name())
+	}
+}
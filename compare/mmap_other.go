@@ -0,0 +1,10 @@
+//go:build !unix
+
+package compare
+
+import "os"
+
+// cmpMmap is not supported on this platform, so files are always read instead.
+func (c *comparer) cmpMmap(f1 *os.File, f2 *os.File, size int64) (eq bool, offset int64, ok bool) {
+	return false, -1, false
+}
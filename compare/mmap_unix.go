@@ -0,0 +1,44 @@
+//go:build unix
+
+package compare
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+)
+
+// cmpMmap compares two files of the given size by memory mapping them. ok is false if the files could not be mapped,
+// in which case the caller should fall back to reading them.
+func (c *comparer) cmpMmap(f1 *os.File, f2 *os.File, size int64) (eq bool, offset int64, ok bool) {
+	if size == 0 {
+		return true, -1, true
+	}
+	if int64(int(size)) != size {
+		return false, -1, false
+	}
+
+	b1, err := syscall.Mmap(int(f1.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return false, -1, false
+	}
+	defer syscall.Munmap(b1)
+	b2, err := syscall.Mmap(int(f2.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return false, -1, false
+	}
+	defer syscall.Munmap(b2)
+
+	for pos := 0; pos < len(b1); pos += MMAP_CHUNK_SIZE {
+		if c.stopped() {
+			return true, -1, true
+		}
+
+		end := min(pos+MMAP_CHUNK_SIZE, len(b1))
+		c.read(int64(2 * (end - pos)))
+		if !bytes.Equal(b1[pos:end], b2[pos:end]) {
+			return false, int64(pos + firstMismatch(b1[pos:end], b2[pos:end])), true
+		}
+	}
+	return true, -1, true
+}
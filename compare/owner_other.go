@@ -0,0 +1,13 @@
+//go:build !unix
+
+package compare
+
+import "os"
+
+// Whether file owners can be compared on this platform.
+const ownerSupported = false
+
+// owner is not supported on this platform, so the owner is never known.
+func owner(stat os.FileInfo) (string, bool) {
+	return "", false
+}
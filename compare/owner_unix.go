@@ -0,0 +1,21 @@
+//go:build unix
+
+package compare
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Whether file owners can be compared on this platform.
+const ownerSupported = true
+
+// owner returns the owner of a file as uid:gid. ok is false if it is not known.
+func owner(stat os.FileInfo) (string, bool) {
+	s, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%d:%d", s.Uid, s.Gid), true
+}
@@ -0,0 +1,85 @@
+package compare
+
+import (
+	"strings"
+)
+
+// Path used in patches for the missing side of a file only present on one side.
+const DEV_NULL = "/dev/null"
+
+// label returns the name of a file on the given side as used in the headers of unified diffs. In patches it is the
+// path relative to the compared path of its side, prefixed by a/ or b/ as git does, so that patch -p1 applies it.
+// Compared files are labelled by their base names.
+func (c *comparer) label(side int, file string) string {
+	if !c.opts.Patch {
+		return file
+	}
+
+	root := c.roots[side-1]
+	_, rel := c.split(side, file)
+	if file != root {
+		rel = relPath(root, file)
+	}
+	return string("ab"[side-1]) + "/" + rel
+}
+
+// reportOnly reports an entry of a directory on the given side which is only present on that side. In patches the
+// difference holds the whole entry as an addition or deletion, which for a directory is every text file below it.
+func (c *comparer) reportOnly(side int, dir string, name string) {
+	d := Difference{Type: ONLY_IN, Dir: dir, Name: name, Side: side}
+	if c.opts.Patch && !c.opts.Plan && !c.opts.StatOnly {
+		var sb strings.Builder
+		if err := c.patchOnly(side, c.join(side, dir, name), &sb); err != nil {
+			c.fail(err)
+			return
+		}
+		d.Diff = sb.String()
+	}
+	c.report(d)
+}
+
+// patchOnly writes the diff adding or deleting a file only present on the given side, or every text file below it if it
+// is a directory, to sb. Binary files and symlinks cannot be patched and are left out.
+func (c *comparer) patchOnly(side int, name string, sb *strings.Builder) error {
+	if c.stopped() {
+		return nil
+	}
+
+	info, err := c.fs(side, name).Lstat(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		entries, err := c.fs(side, name).ReadDir(name)
+		if err != nil {
+			return err
+		}
+		for _, e := range c.filter(side, name, relPath(c.roots[side-1], name), entries) {
+			if err := c.patchOnly(side, c.join(side, name, e.Name()), sb); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	if !c.acquire() {
+		return nil
+	}
+	data, err := c.readFile(side, name)
+	c.release()
+	if err != nil {
+		return err
+	}
+	if isBinary(data) {
+		return nil
+	}
+	if side == 1 {
+		sb.WriteString(unified(c.label(1, name), DEV_NULL, string(data), "", c.opts.Context))
+	} else {
+		sb.WriteString(unified(DEV_NULL, c.label(2, name), "", string(data), c.opts.Context))
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package compare
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPatchEmptyDir checks that files whose directory is empty on the other side are added whole to patches, instead
+// of being reported together as an empty directory.
+func TestPatchEmptyDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"b/sub/new": "line\n"})
+	if err := os.MkdirAll(filepath.Join(dir, "a", "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{Recursive: true, Patch: true, Context: 3}
+	diffs, err := Dirs(context.Background(), filepath.Join(dir, "a"), filepath.Join(dir, "b"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--- /dev/null\n+++ b/sub/new\n@@ -0,0 +1 @@\n+line\n"
+	if len(diffs) != 1 || diffs[0].Type != ONLY_IN || diffs[0].Diff != want {
+		t.Errorf("got %+v, want the addition of sub/new", diffs)
+	}
+}
@@ -0,0 +1,29 @@
+package compare
+
+import "sync/atomic"
+
+// Progress counts the work done so far while comparing. It is updated atomically so it can be read concurrently, for
+// example to periodically print a status line.
+type Progress struct {
+	// Number of pairs of files compared.
+	Files atomic.Int64
+	// Number of bytes read from both sides when comparing files by their contents.
+	Bytes atomic.Int64
+}
+
+// read adds n bytes read to the progress and stats, if they are tracked.
+func (c *comparer) read(n int64) {
+	if c.opts.Progress != nil {
+		c.opts.Progress.Bytes.Add(n)
+	}
+	if c.opts.Stats != nil {
+		c.opts.Stats.Bytes.Add(n)
+	}
+}
+
+// compared counts a pair of files as compared, if progress is tracked.
+func (c *comparer) compared() {
+	if c.opts.Progress != nil {
+		c.opts.Progress.Files.Add(1)
+	}
+}
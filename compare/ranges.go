@@ -0,0 +1,86 @@
+package compare
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Minimum size in bytes of files split into ranges compared in parallel with ThreadsPerFile. Smaller files are read
+// faster by a single reader.
+const RANGES_MIN_SIZE = 64 * 1024 * 1024
+
+// cmpRanges compares two files of the given size byte for byte by splitting them into n ranges, each compared by its
+// own reader with ReadAt, which keeps fast storage busy where a single sequential reader would not. It returns whether
+// the files are equal, and if not the zero based offset of the first differing byte. Ranges after a difference stop as
+// soon as it is found, while those before it keep going since they may hold an earlier one.
+func (c *comparer) cmpRanges(f1 file, f2 file, size int64, n int) (bool, int64, error) {
+	bufSize := c.opts.BufferSize
+	if bufSize <= 0 {
+		bufSize = BUFFER_SIZE
+	}
+
+	// Offset of the first difference found so far, or size if none has been.
+	var first atomic.Int64
+	first.Store(size)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		start, end := size*int64(i)/int64(n), size*int64(i+1)/int64(n)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b1, b2 := make([]byte, bufSize), make([]byte, bufSize)
+			for pos := start; pos < end && pos < first.Load() && !c.stopped(); {
+				l := int(min(int64(bufSize), end-pos))
+				if _, err := readAt(f1, b1[:l], pos); err != nil {
+					errs[i] = err
+					return
+				}
+				if _, err := readAt(f2, b2[:l], pos); err != nil {
+					errs[i] = err
+					return
+				}
+				c.read(int64(2 * l))
+				if !bytes.Equal(b1[:l], b2[:l]) {
+					offset := pos + int64(firstMismatch(b1[:l], b2[:l]))
+					for {
+						old := first.Load()
+						if offset >= old || first.CompareAndSwap(old, offset) {
+							return
+						}
+					}
+				}
+				pos += int64(l)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return false, -1, err
+		}
+	}
+	if c.stopped() {
+		return true, -1, nil
+	}
+	if offset := first.Load(); offset < size {
+		return false, offset, nil
+	}
+	return true, -1, nil
+}
+
+// readAt fills b from r at the given offset. Reaching the end of r exactly as b is filled is not an error, while a file
+// which has shrunk since its size was taken is.
+func readAt(r io.ReaderAt, b []byte, off int64) (int, error) {
+	n, err := r.ReadAt(b, off)
+	if err == io.EOF && n == len(b) {
+		err = nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
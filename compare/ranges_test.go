@@ -0,0 +1,24 @@
+package compare
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// BenchmarkThreadsPerFile compares two equal files of RANGES_MIN_SIZE bytes split into several ranges compared in
+// parallel, the first case comparing them with a single reader.
+func BenchmarkThreadsPerFile(b *testing.B) {
+	file1, file2 := largeFiles(b, RANGES_MIN_SIZE)
+	for _, threads := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads=%v", threads), func(b *testing.B) {
+			c := newComparer(context.Background(), Options{ThreadsPerFile: threads})
+			b.SetBytes(2 * RANGES_MIN_SIZE)
+			for i := 0; i < b.N; i++ {
+				if d, err := c.cmpFiles(file1, file2); d != nil || err != nil {
+					b.Fatal(d, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package compare
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Port remote hosts are connected to over SSH.
+const SSH_PORT = "22"
+
+// splitRemote splits a remote path of the form [user@]host:path into its prefix, which is everything up to and
+// including the colon, and the path on the remote host. Like scp, a path is only remote if the colon comes before any
+// slash. Single letters before the colon are taken to be Windows drive letters instead of hosts.
+func splitRemote(name string) (prefix string, rest string, ok bool) {
+	i := strings.IndexByte(name, ':')
+	if i <= 0 || strings.ContainsAny(name[:i], `/\`) {
+		return "", name, false
+	}
+	if i == 1 && filepath.VolumeName(name) != "" {
+		return "", name, false
+	}
+	return name[:i+1], name[i+1:], true
+}
+
+// IsRemote returns whether a path refers to a remote host, in the form [user@]host:path.
+func IsRemote(name string) bool {
+	_, _, ok := splitRemote(name)
+	return ok
+}
+
+// sftpFS is the filesystem of a remote host accessed over SFTP. Names include the remote prefix, which is stripped
+// before they are passed on.
+type sftpFS struct {
+	prefix string
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+// dialRemote connects to the host of a remote prefix over SSH. The user defaults to the current one. Authentication
+// uses the SSH agent if one is running and the default unencrypted private keys in ~/.ssh, and host keys are verified
+// against ~/.ssh/known_hosts.
+func dialRemote(prefix string) (*sftpFS, error) {
+	host := strings.TrimSuffix(prefix, ":")
+	name := ""
+	if i := strings.LastIndexByte(host, '@'); i >= 0 {
+		name, host = host[:i], host[i+1:]
+	}
+	if name == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		name = u.Username
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot verify host keys: %w", err)
+	}
+
+	var auth []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if a, err := net.Dial("unix", sock); err == nil {
+			defer a.Close()
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(a).Signers))
+		}
+	}
+	var signers []ssh.Signer
+	for _, key := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		data, err := os.ReadFile(filepath.Join(home, ".ssh", key))
+		if err != nil {
+			continue
+		}
+		if signer, err := ssh.ParsePrivateKey(data); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(host, SSH_PORT), &ssh.ClientConfig{
+		User:            name,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &sftpFS{prefix: prefix, conn: conn, client: client}, nil
+}
+
+// path returns the path on the remote host of a name. An empty path is the home directory of the user.
+func (s *sftpFS) path(name string) string {
+	p := strings.TrimPrefix(name, s.prefix)
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// pathErr returns an error as a path error for the name, so that errors mention the remote host and can be told apart
+// by path like local ones.
+func (s *sftpFS) pathErr(op string, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return &fs.PathError{Op: pathErr.Op, Path: name, Err: pathErr.Err}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (s *sftpFS) Open(name string) (file, error) {
+	f, err := s.client.Open(s.path(name))
+	if err != nil {
+		return nil, s.pathErr("open", name, err)
+	}
+	return f, nil
+}
+
+func (s *sftpFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := s.client.Stat(s.path(name))
+	return info, s.pathErr("stat", name, err)
+}
+
+func (s *sftpFS) Lstat(name string) (fs.FileInfo, error) {
+	info, err := s.client.Lstat(s.path(name))
+	return info, s.pathErr("lstat", name, err)
+}
+
+func (s *sftpFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	infos, err := s.client.ReadDir(s.path(name))
+	if err != nil {
+		return nil, s.pathErr("readdir", name, err)
+	}
+	// Entries are sorted by name like os.ReadDir does.
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	entries := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = fs.FileInfoToDirEntry(info)
+	}
+	return entries, nil
+}
+
+// OpenDir reads the whole directory at once, as the SFTP client cannot read one a batch at a time.
+func (s *sftpFS) OpenDir(name string) (dirFile, error) {
+	entries, err := s.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return &listedDir{entries}, nil
+}
+
+func (s *sftpFS) Readlink(name string) (string, error) {
+	target, err := s.client.ReadLink(s.path(name))
+	return target, s.pathErr("readlink", name, err)
+}
+
+func (s *sftpFS) RealPath(name string) (string, error) {
+	p, err := s.client.RealPath(s.path(name))
+	if err != nil {
+		return "", s.pathErr("realpath", name, err)
+	}
+	return s.prefix + p, nil
+}
+
+// Close closes the connection to the remote host.
+func (s *sftpFS) Close() error {
+	s.client.Close()
+	return s.conn.Close()
+}
@@ -0,0 +1,92 @@
+package compare
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/fs"
+)
+
+// detectRenames pairs up files only present in dir1 with files of identical contents only present in dir2 and reports
+// them as renamed. It returns the entries which could not be paired.
+func (c *comparer) detectRenames(
+	dir1 string, dir2 string, only1 []fs.DirEntry, only2 []fs.DirEntry,
+) ([]fs.DirEntry, []fs.DirEntry) {
+	if len(only1) == 0 || len(only2) == 0 {
+		return only1, only2
+	}
+
+	algo := c.opts.Hash
+	if algo == "" {
+		algo = SHA256
+	}
+
+	// Hash all files in the second directory. Directories are never paired.
+	hashes := make(map[string][]fs.DirEntry)
+	for _, f := range only2 {
+		if h, ok := c.hashEntry(2, dir2, f, algo); ok {
+			hashes[h] = append(hashes[h], f)
+		}
+	}
+
+	// Match files in the first directory against them, each file in the second directory being used at most once.
+	var rest1 []fs.DirEntry
+	paired := make(map[string]bool)
+	for _, f := range only1 {
+		h, ok := c.hashEntry(1, dir1, f, algo)
+		if !ok || len(hashes[h]) == 0 {
+			rest1 = append(rest1, f)
+			continue
+		}
+
+		f2 := hashes[h][0]
+		hashes[h] = hashes[h][1:]
+		paired[f2.Name()] = true
+		c.report(Difference{Type: RENAMED, Path1: c.join(1, dir1, f.Name()), Path2: c.join(2, dir2, f2.Name())})
+	}
+
+	// The rest of the second directory is kept in order, so that it is reported in the same order every time.
+	var rest2 []fs.DirEntry
+	for _, f := range only2 {
+		if !paired[f.Name()] {
+			rest2 = append(rest2, f)
+		}
+	}
+
+	return rest1, rest2
+}
+
+// hashEntry returns the hex encoded digest of a directory entry on the given side. ok is false if the entry is a
+// directory or could not be hashed, in which case the error is recorded.
+func (c *comparer) hashEntry(side int, dir string, e fs.DirEntry, algo string) (string, bool) {
+	if c.stopped() {
+		return "", false
+	}
+
+	// Broken symlinks cannot be hashed, they remain only in their directory. Neither do symlinks when not following
+	// them, or special files.
+	if (!c.follows(side) && isLink(e)) || isSpecial(e) {
+		return "", false
+	}
+	d, err := c.isDir(side, dir, e)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false
+	}
+	if err != nil {
+		c.fail(err)
+		return "", false
+	}
+	if d {
+		return "", false
+	}
+
+	if !c.acquire() {
+		return "", false
+	}
+	h, err := c.hashFile(side, c.join(side, dir, e.Name()), algo)
+	c.release()
+	if err != nil {
+		c.fail(err)
+		return "", false
+	}
+	return hex.EncodeToString(h), true
+}
@@ -0,0 +1,32 @@
+package compare
+
+import "sync/atomic"
+
+// Result aggregates whether the compared paths differ and whether some items could not be compared, from every
+// difference reported anywhere in the comparison, including those dropped by Brief or Limit. It is updated atomically
+// by the goroutines comparing subdirectories and files, so it can be read as soon as the comparison is done to decide
+// the exit status, without inspecting the differences themselves.
+type Result struct {
+	differ     atomic.Bool
+	incomplete atomic.Bool
+}
+
+// Differ returns whether any actual difference was reported, as told by Difference.IsDifference.
+func (r *Result) Differ() bool {
+	return r.differ.Load()
+}
+
+// Incomplete returns whether any item could not be compared, as told by Difference.Incomplete.
+func (r *Result) Incomplete() bool {
+	return r.incomplete.Load()
+}
+
+// add updates the result for a reported difference.
+func (r *Result) add(d Difference) {
+	if d.IsDifference() {
+		r.differ.Store(true)
+	}
+	if d.Incomplete() {
+		r.incomplete.Store(true)
+	}
+}
@@ -0,0 +1,52 @@
+package compare
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestResultNested checks that a difference found several levels deep in a tree, or a file there which cannot be
+// read, is recorded in the result of the comparison, which is otherwise empty.
+func TestResultNested(t *testing.T) {
+	deep := "1/2/3/4/5/file"
+	for _, test := range []struct {
+		name               string
+		data1, data2       string
+		unreadable         bool
+		differ, incomplete bool
+	}{
+		{"equal", "x", "x", false, false, false},
+		{"differ", "x", "y", false, true, false},
+		{"unreadable", "x", "x", true, false, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"a/top": "x", "b/top": "x", "a/" + deep: test.data1, "b/" + deep: test.data2,
+			})
+			if test.unreadable {
+				if os.Getuid() == 0 {
+					t.Skip("files are always readable as root")
+				}
+				if err := os.Chmod(filepath.Join(dir, "b", filepath.FromSlash(deep)), 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var result Result
+			opts := Options{Recursive: true, Result: &result}
+			diffs, err := Diff(context.Background(), filepath.Join(dir, "a"), filepath.Join(dir, "b"), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range diffs {
+			}
+			if result.Differ() != test.differ || result.Incomplete() != test.incomplete {
+				t.Errorf("got differ %v and incomplete %v, want %v and %v", result.Differ(), result.Incomplete(),
+					test.differ, test.incomplete)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package compare
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Keys which can be written after a dot in a path, other keys are quoted in brackets.
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// isJSON returns whether a file is a JSON file by its extension.
+func isJSON(file string) bool {
+	return strings.EqualFold(path.Ext(file), ".json")
+}
+
+// isYAML returns whether a file is a YAML file by its extension.
+func isYAML(file string) bool {
+	ext := strings.ToLower(path.Ext(file))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// semantic returns whether two files are compared by the data they hold, or as sets of lines, in which case files of
+// different sizes may still be equal.
+func (c *comparer) semantic(file1 string, file2 string) bool {
+	return c.comparatorName(file1, file2) != COMPARE_BYTES
+}
+
+// decodeJSON decodes a JSON document.
+func decodeJSON(data []byte) ([]any, error) {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return []any{v}, nil
+}
+
+// decodeYAML decodes all documents of a YAML stream. Aliases are resolved, and maps with keys other than strings have
+// their keys turned into strings so that they can be compared like JSON objects.
+func decodeYAML(data []byte) ([]any, error) {
+	var docs []any
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var v any
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, normalize(v))
+	}
+}
+
+// normalize turns maps keyed by any type, as YAML allows, into maps keyed by strings.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = normalize(e)
+		}
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case []any:
+		for i, e := range v {
+			v[i] = normalize(e)
+		}
+	}
+	return v
+}
+
+// cmpSemantic compares two files by the data they hold rather than by their bytes, so that formatting and the order
+// of keys do not matter. The files are decoded into documents using decode, and documents are compared in order. It
+// returns the paths of the values which differ, prefixed by the number of the document if there are several. ok is
+// false if either file cannot be decoded, which is reported as a warning of type invalid, and the files should be
+// compared byte for byte instead.
+func (c *comparer) cmpSemantic(
+	file1 string, file2 string, decode func([]byte) ([]any, error), invalid string,
+) (paths []string, ok bool, err error) {
+	var docs [2][]any
+	for i, file := range []string{file1, file2} {
+		data, err := c.readFile(i+1, file)
+		if err != nil {
+			return nil, false, err
+		}
+		c.read(int64(len(data)))
+		if docs[i], err = decode(data); err != nil {
+			c.report(Difference{Type: invalid, Path1: file, Error: err.Error()})
+			return nil, false, nil
+		}
+	}
+
+	n := max(len(docs[0]), len(docs[1]))
+	for i := 0; i < n; i++ {
+		root := "$"
+		if n > 1 {
+			root = fmt.Sprintf("%d:$", i+1)
+		}
+		if i < len(docs[0]) && i < len(docs[1]) {
+			paths = diffValues(root, docs[0][i], docs[1][i], paths)
+		} else {
+			paths = append(paths, root)
+		}
+	}
+	return paths, true, nil
+}
+
+// diffValues appends the paths of the values which differ between two decoded values at path p to paths. Values
+// only present on one side differ as well.
+func diffValues(p string, v1 any, v2 any, paths []string) []string {
+	switch a := v1.(type) {
+	case map[string]any:
+		b, ok := v2.(map[string]any)
+		if !ok {
+			return append(paths, p)
+		}
+		keys := make([]string, 0, len(a)+len(b))
+		for k := range a {
+			keys = append(keys, k)
+		}
+		for k := range b {
+			if _, ok := a[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			e1, ok1 := a[k]
+			e2, ok2 := b[k]
+			if ok1 && ok2 {
+				paths = diffValues(keyPath(p, k), e1, e2, paths)
+			} else {
+				paths = append(paths, keyPath(p, k))
+			}
+		}
+		return paths
+	case []any:
+		b, ok := v2.([]any)
+		if !ok {
+			return append(paths, p)
+		}
+		for i := 0; i < max(len(a), len(b)); i++ {
+			if i < len(a) && i < len(b) {
+				paths = diffValues(fmt.Sprintf("%v[%d]", p, i), a[i], b[i], paths)
+			} else {
+				paths = append(paths, fmt.Sprintf("%v[%d]", p, i))
+			}
+		}
+		return paths
+	}
+
+	// Anything else is a scalar such as a number, string, boolean or null, all of which can be compared directly.
+	if v1 != v2 {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// keyPath returns the path of the member of an object at path p with key k.
+func keyPath(p string, k string) string {
+	if identifier.MatchString(k) {
+		return p + "." + k
+	}
+	return p + "[" + strconv.Quote(k) + "]"
+}
@@ -0,0 +1,126 @@
+package compare
+
+import (
+	"bufio"
+	"errors"
+	"hash/fnv"
+	"io"
+	"slices"
+)
+
+// Size in bytes of the blocks matched between files when estimating their similarity.
+const SIMILARITY_BLOCK_SIZE = 256
+
+// weakSum returns the rolling checksum of a block, as used by rsync, split into its two halves.
+func weakSum(block []byte) (uint32, uint32) {
+	var a, b uint32
+	for i, x := range block {
+		a += uint32(x)
+		b += uint32(len(block)-i) * uint32(x)
+	}
+	return a & 0xffff, b & 0xffff
+}
+
+// strongSum returns the digest used to confirm that blocks with equal rolling checksums are equal.
+func strongSum(block []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(block)
+	return h.Sum64()
+}
+
+// similarity estimates how similar two files are, as the percentage of their bytes found in blocks common to both.
+// The blocks of the first file are looked up at every offset of the second one using a rolling checksum, so that
+// insertions and deletions only affect the blocks around them. This is an estimate and not a true edit distance.
+func (c *comparer) similarity(file1 string, file2 string) (int, error) {
+	f1, err := c.open(1, file1)
+	if err != nil {
+		return 0, err
+	}
+	defer f1.Close()
+	stat1, err := f1.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	f2, err := c.open(2, file2)
+	if err != nil {
+		return 0, err
+	}
+	defer f2.Close()
+	stat2, err := f2.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	total := stat1.Size() + stat2.Size()
+	if total == 0 {
+		return 100, nil
+	}
+
+	// Index the whole blocks of the first file by their rolling checksums. A trailing partial block never matches.
+	blocks := make(map[uint32][]uint64)
+	r1 := bufio.NewReaderSize(f1, BUFFER_SIZE)
+	block := make([]byte, SIMILARITY_BLOCK_SIZE)
+	for {
+		if _, err := io.ReadFull(r1, block); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return 0, err
+		}
+		a, b := weakSum(block)
+		blocks[a|b<<16] = append(blocks[a|b<<16], strongSum(block))
+	}
+
+	// Slide a window over the second file. On a match the window jumps past the matched block, and that block of the
+	// first file is used up so that repeated content is not counted twice.
+	var matched int64
+	r2 := bufio.NewReaderSize(f2, BUFFER_SIZE)
+	window := make([]byte, SIMILARITY_BLOCK_SIZE)
+	fill := func() (bool, error) {
+		_, err := io.ReadFull(r2, window)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	ok, err := fill()
+	if err != nil {
+		return 0, err
+	}
+	a, b := weakSum(window)
+	start := 0
+	for ok {
+		if sums := blocks[a|b<<16]; len(sums) > 0 {
+			copy(block, window[start:])
+			copy(block[SIMILARITY_BLOCK_SIZE-start:], window[:start])
+			strong := strongSum(block)
+			if i := slices.Index(sums, strong); i >= 0 {
+				sums[i] = sums[len(sums)-1]
+				blocks[a|b<<16] = sums[:len(sums)-1]
+				matched += SIMILARITY_BLOCK_SIZE
+				if ok, err = fill(); err != nil {
+					return 0, err
+				}
+				a, b = weakSum(window)
+				start = 0
+				continue
+			}
+		}
+
+		x, err := r2.ReadByte()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		out := uint32(window[start])
+		window[start] = x
+		start = (start + 1) % SIMILARITY_BLOCK_SIZE
+		a = (a - out + uint32(x)) & 0xffff
+		b = (b - SIMILARITY_BLOCK_SIZE*out + a) & 0xffff
+	}
+
+	return int(2 * matched * 100 / total), nil
+}
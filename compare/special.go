@@ -0,0 +1,73 @@
+package compare
+
+import "io/fs"
+
+// isSpecial returns whether a directory entry is a special file, such as a named pipe, a socket or a device, without
+// following symlinks.
+func isSpecial(e fs.DirEntry) bool {
+	return !isLink(e) && !e.IsDir() && !e.Type().IsRegular()
+}
+
+// specialKind returns the kind of a special file by its mode, as used in type mismatches. It is empty for regular
+// files.
+func specialKind(mode fs.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return ""
+	case mode&fs.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeCharDevice != 0:
+		return "character device"
+	case mode&fs.ModeDevice != 0:
+		return "block device"
+	}
+	return "special file"
+}
+
+// diffSpecial compares two files, neither of them a directory, if either is a special file. Special files are never
+// read, since reading a named pipe or a device may block forever or never end. Instead they are compared by their
+// kinds, and devices also by the device numbers they stand for. It returns false if both files are regular, which are
+// left to be compared by their contents.
+func (c *comparer) diffSpecial(file1 string, file2 string) bool {
+	stat1, err := c.stat(1, file1)
+	if err != nil {
+		c.fail(err)
+		return true
+	}
+	stat2, err := c.stat(2, file2)
+	if err != nil {
+		c.fail(err)
+		return true
+	}
+	kind1, kind2 := specialKind(stat1.Mode()), specialKind(stat2.Mode())
+	if kind1 == "" && kind2 == "" {
+		return false
+	}
+	defer c.compared()
+
+	if kind1 != kind2 {
+		if kind1 == "" {
+			kind1 = "file"
+		} else if kind2 == "" {
+			kind2 = "file"
+		}
+		c.report(Difference{Type: TYPE_MISMATCH, Path1: file1, Path2: file2, Kind1: kind1, Kind2: kind2})
+		return true
+	}
+	if stat1.Mode()&fs.ModeDevice != 0 {
+		dev1, ok1 := deviceNumber(stat1)
+		dev2, ok2 := deviceNumber(stat2)
+		if ok1 && ok2 && dev1 != dev2 {
+			c.report(Difference{
+				Type: DEVICES_DIFFER, Path1: file1, Path2: file2, Kind1: kind1, Device1: dev1, Device2: dev2,
+			})
+			return true
+		}
+	}
+	if c.opts.ReportIdentical {
+		c.report(Difference{Type: IDENTICAL, Path1: file1, Path2: file2})
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package compare
+
+import (
+	"io/fs"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats counts reported differences by type, along with the bytes read and the entries examined. It is updated
+// atomically while comparing so it can be read concurrently.
+type Stats struct {
+	FilesDiffer    atomic.Int64
+	OnlyIn1        atomic.Int64
+	OnlyIn2        atomic.Int64
+	TypeMismatches atomic.Int64
+	// Number of bytes read from both sides when comparing files by their contents.
+	Bytes atomic.Int64
+	// Number of files and directories examined in total, the entries of the compared directories present on either
+	// side which are not left out.
+	Files atomic.Int64
+	Dirs  atomic.Int64
+
+	mu sync.Mutex
+	// Entries examined by the relative path of their directory.
+	examined map[string]Examined
+}
+
+// Examined is the number of files and directories examined in a single directory, not counting those below them.
+type Examined struct {
+	Files int64 `json:"files"`
+	Dirs  int64 `json:"dirs"`
+}
+
+// count updates the stats for a reported difference.
+func (s *Stats) count(d Difference) {
+	switch d.Type {
+	case FILES_DIFFER:
+		s.FilesDiffer.Add(1)
+	case ONLY_IN:
+		if d.Side == 1 {
+			s.OnlyIn1.Add(1)
+		} else {
+			s.OnlyIn2.Add(1)
+		}
+	case TYPE_MISMATCH:
+		s.TypeMismatches.Add(1)
+	}
+}
+
+// Examined returns the entries examined so far by the relative path of their directory, "" being the compared
+// directories themselves.
+func (s *Stats) Examined() map[string]Examined {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	examined := make(map[string]Examined, len(s.examined))
+	for rel, e := range s.examined {
+		examined[rel] = e
+	}
+	return examined
+}
+
+// examine counts the entries of a pair of directories at the given relative path as examined, if stats are tracked.
+// Entries present on both sides are counted once, as a directory if they are one on either side. Symlinks which cannot
+// be followed count as files.
+func (c *comparer) examine(rel string, dir1 string, dir2 string, files1 []fs.DirEntry, files2 []fs.DirEntry) {
+	s := c.opts.Stats
+	if s == nil {
+		return
+	}
+
+	dirs := make(map[string]bool, len(files1)+len(files2))
+	for i, files := range [][]fs.DirEntry{files1, files2} {
+		keys := c.keys(files)
+		for j, f := range files {
+			dirs[keys[j]] = dirs[keys[j]] || c.examinedDir(i+1, []string{dir1, dir2}[i], f)
+		}
+	}
+	var e Examined
+	for _, dir := range dirs {
+		e.add(dir)
+	}
+	c.addExamined(rel, e)
+}
+
+// examinedDir returns whether an entry of the directory dir on the given side counts as a directory when examined.
+func (c *comparer) examinedDir(side int, dir string, f fs.DirEntry) bool {
+	if isLink(f) && c.follows(side) {
+		d, _ := c.isDir(side, dir, f)
+		return d
+	}
+	return f.IsDir()
+}
+
+// add counts an entry as examined, as a directory or a file.
+func (e *Examined) add(dir bool) {
+	if dir {
+		e.Dirs++
+	} else {
+		e.Files++
+	}
+}
+
+// addExamined records the entries examined in a pair of directories at the given relative path in the stats, which
+// must be tracked.
+func (c *comparer) addExamined(rel string, e Examined) {
+	s := c.opts.Stats
+	s.Files.Add(e.Files)
+	s.Dirs.Add(e.Dirs)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.examined == nil {
+		s.examined = make(map[string]Examined)
+	}
+	s.examined[rel] = e
+}
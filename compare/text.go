@@ -0,0 +1,230 @@
+package compare
+
+import (
+	"bufio"
+	"io"
+	"unicode"
+	"unicode/utf8"
+)
+
+// text returns whether any option asks for files to be compared as text, in which case files of different sizes may
+// still be equal.
+func (c *comparer) text() bool {
+	return c.opts.IgnoreTrailingNewline || c.opts.IgnoreLineEndings || c.opts.IgnoreWhitespace ||
+		c.opts.IgnoreBlankLines || c.opts.IgnoreContentCase
+}
+
+// cmpContents compares two readers as text if any option asks for it, or byte for byte otherwise.
+func (c *comparer) cmpContents(r1 io.Reader, r2 io.Reader) (bool, int64, error) {
+	if c.text() {
+		return c.cmpText(r1, r2)
+	}
+	return c.cmpReaders(r1, r2)
+}
+
+// cmpText compares two readers as text, ignoring the differences the options ask for. If either reader looks binary
+// they are compared byte for byte instead. The offset of the first difference is only returned for readers compared
+// byte for byte, as one in the normalized text is not the same in the readers.
+func (c *comparer) cmpText(r1 io.Reader, r2 io.Reader) (bool, int64, error) {
+	br1 := bufio.NewReaderSize(r1, max(c.opts.BufferSize, SNIFF_SIZE))
+	br2 := bufio.NewReaderSize(r2, max(c.opts.BufferSize, SNIFF_SIZE))
+	sniff1, err := br1.Peek(SNIFF_SIZE)
+	if err != nil && err != io.EOF {
+		return false, -1, err
+	}
+	sniff2, err := br2.Peek(SNIFF_SIZE)
+	if err != nil && err != io.EOF {
+		return false, -1, err
+	}
+	if isBinary(sniff1) || isBinary(sniff2) {
+		return c.cmpReaders(br1, br2)
+	}
+
+	// Line endings are normalized first, so that a trailing CRLF is dropped as a whole.
+	if c.opts.IgnoreLineEndings {
+		br1, br2 = bufio.NewReader(&lineEndingReader{br1}), bufio.NewReader(&lineEndingReader{br2})
+	}
+	if c.opts.IgnoreContentCase {
+		br1, br2 = bufio.NewReader(&caseReader{r: br1}), bufio.NewReader(&caseReader{r: br2})
+	}
+	if c.opts.IgnoreWhitespace {
+		br1, br2 = bufio.NewReader(&whitespaceReader{r: br1}), bufio.NewReader(&whitespaceReader{r: br2})
+	}
+	if c.opts.IgnoreBlankLines {
+		br1, br2 = bufio.NewReader(&blankLineReader{r: br1}), bufio.NewReader(&blankLineReader{r: br2})
+	}
+	var t1, t2 io.Reader = br1, br2
+	if c.opts.IgnoreTrailingNewline {
+		t1, t2 = &trailingNewlineReader{br1}, &trailingNewlineReader{br2}
+	}
+	eq, _, err := c.cmpReaders(t1, t2)
+	return eq, -1, err
+}
+
+// trailingNewlineReader reads from a reader, dropping a single newline at its very end.
+type trailingNewlineReader struct {
+	r *bufio.Reader
+}
+
+func (t *trailingNewlineReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 && p[n-1] == '\n' {
+		if _, perr := t.r.Peek(1); perr == io.EOF {
+			n--
+		}
+	}
+	return n, err
+}
+
+// lineEndingReader reads from a reader, turning CRLF line endings into LF.
+type lineEndingReader struct {
+	r *bufio.Reader
+}
+
+func (l *lineEndingReader) Read(p []byte) (int, error) {
+	// Only bytes already buffered are read after the first one, so that reads do not block longer than needed.
+	n := 0
+	for n < len(p) && (n == 0 || l.r.Buffered() > 0) {
+		b, err := l.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b == '\r' {
+			if next, err := l.r.Peek(1); err == nil && next[0] == '\n' {
+				continue
+			}
+		}
+		p[n] = b
+		n++
+	}
+	return n, nil
+}
+
+// caseReader reads from a reader, turning every letter into lower case. Bytes which are not valid UTF-8 are kept as
+// they are, so that they only equal themselves.
+type caseReader struct {
+	r *bufio.Reader
+	// Bytes of a lowercased letter which did not fit into the last read.
+	pending []byte
+}
+
+func (c *caseReader) Read(p []byte) (int, error) {
+	// Only bytes already buffered are read after the first one, so that reads do not block longer than needed.
+	n := 0
+	for n < len(p) && (n == 0 || c.r.Buffered() > 0 || len(c.pending) > 0) {
+		if len(c.pending) > 0 {
+			m := copy(p[n:], c.pending)
+			c.pending = c.pending[m:]
+			n += m
+			continue
+		}
+
+		r, size, err := c.r.ReadRune()
+		if err != nil {
+			return n, err
+		}
+		switch {
+		case r < utf8.RuneSelf:
+			p[n] = byte(unicode.ToLower(r))
+			n++
+		case r == utf8.RuneError && size == 1:
+			c.r.UnreadRune()
+			p[n], _ = c.r.ReadByte()
+			n++
+		default:
+			c.pending = utf8.AppendRune(c.pending[:0], unicode.ToLower(r))
+		}
+	}
+	return n, nil
+}
+
+// isSpace returns whether a byte is whitespace within a line, which is a space, tab, carriage return, vertical tab or
+// form feed.
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\v' || b == '\f'
+}
+
+// whitespaceReader reads from a reader, dropping whitespace at the start and end of each line and collapsing every
+// other run of whitespace into a single space. Newlines are kept as they are.
+type whitespaceReader struct {
+	r *bufio.Reader
+	// Whether a byte other than whitespace has been read on the current line.
+	text bool
+	// Whether whitespace has been read since the last byte which is not whitespace.
+	space bool
+}
+
+func (w *whitespaceReader) Read(p []byte) (int, error) {
+	// Only bytes already buffered are read after the first one, so that reads do not block longer than needed.
+	n := 0
+	for n < len(p) && (n == 0 || w.r.Buffered() > 0) {
+		b, err := w.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		switch {
+		case isSpace(b):
+			w.space = w.text
+		case b == '\n':
+			p[n] = b
+			n++
+			w.text, w.space = false, false
+		case w.space:
+			// The space is written first and the byte is read again, as p may have no room for both.
+			w.r.UnreadByte()
+			p[n] = ' '
+			n++
+			w.space = false
+		default:
+			p[n] = b
+			n++
+			w.text = true
+		}
+	}
+	return n, nil
+}
+
+// blankLineReader reads from a reader, dropping lines which are empty or only hold whitespace. Whitespace at the start
+// of other lines is kept.
+type blankLineReader struct {
+	r *bufio.Reader
+	// Whitespace read at the start of the current line, held back until it is known whether the line is blank.
+	pending []byte
+	// Whether a byte other than whitespace has been read on the current line.
+	text bool
+}
+
+func (l *blankLineReader) Read(p []byte) (int, error) {
+	// Only bytes already buffered are read after the first one, so that reads do not block longer than needed.
+	n := 0
+	for n < len(p) && (n == 0 || l.r.Buffered() > 0 || (l.text && len(l.pending) > 0)) {
+		if l.text && len(l.pending) > 0 {
+			m := copy(p[n:], l.pending)
+			l.pending = l.pending[m:]
+			n += m
+			continue
+		}
+
+		b, err := l.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		switch {
+		case !l.text && isSpace(b):
+			l.pending = append(l.pending, b)
+		case !l.text && b == '\n':
+			l.pending = l.pending[:0]
+		default:
+			if !l.text && len(l.pending) > 0 {
+				// The held back whitespace is written first, then the byte is read again.
+				l.r.UnreadByte()
+				l.text = true
+				continue
+			}
+			p[n] = b
+			n++
+			l.text = b != '\n'
+		}
+	}
+	return n, nil
+}
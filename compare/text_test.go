@@ -0,0 +1,76 @@
+package compare
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLineEndingsOffset checks that a file with CRLF line endings and one with LF line endings which differ once they
+// are normalized are reported without an offset, as the offset in the normalized text is not the one in the files,
+// whether they are compared as files, as readers or as entries of archives.
+func TestLineEndingsOffset(t *testing.T) {
+	const crlf, lf = "a\r\nb\r\nX\n", "a\nb\nY\n"
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"crlf": crlf, "lf": lf, "same": "a\nb\nX\n"})
+	zip1, zip2 := filepath.Join(dir, "a.zip"), filepath.Join(dir, "b.zip")
+	writeZip(t, zip1, map[string]string{"file": crlf})
+	writeZip(t, zip2, map[string]string{"file": lf})
+	opts := Options{IgnoreLineEndings: true}
+
+	check := func(how string, diffs []Difference, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diffs) != 1 || diffs[0].Type != FILES_DIFFER {
+			t.Fatalf("%v: got %+v, want a single %v", how, diffs, FILES_DIFFER)
+		}
+		if diffs[0].Offset != nil {
+			t.Errorf("%v: got offset %v, want none", how, *diffs[0].Offset)
+		}
+	}
+	diffs, err := DiffFiles(context.Background(), filepath.Join(dir, "crlf"), filepath.Join(dir, "lf"), opts)
+	check("files", diffs, err)
+	diffs, err = DiffReaders(context.Background(), "crlf", strings.NewReader(crlf), "lf", strings.NewReader(lf), opts)
+	check("readers", diffs, err)
+	diffs, err = DiffFiles(context.Background(), zip1, zip2, opts)
+	check("archives", diffs, err)
+
+	diffs, err = DiffFiles(context.Background(), filepath.Join(dir, "crlf"), filepath.Join(dir, "same"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("got %+v for files differing only by their line endings, want none", diffs)
+	}
+}
+
+// TestNormalizedOffset checks that text which differs once whitespace is collapsed, blank lines are dropped or letters
+// are lowercased is reported without an offset, as each of them may move the first difference.
+func TestNormalizedOffset(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		opts         Options
+		data1, data2 string
+	}{
+		{"whitespace", Options{IgnoreWhitespace: true}, "a   b\nX\n", "a b\nY\n"},
+		{"blank lines", Options{IgnoreBlankLines: true}, "\n\na\nX\n", "a\nY\n"},
+		{"case", Options{IgnoreContentCase: true}, "İ X\n", "i y\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r1, r2 := strings.NewReader(test.data1), strings.NewReader(test.data2)
+			diffs, err := DiffReaders(context.Background(), "1", r1, "2", r2, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(diffs) != 1 || diffs[0].Type != FILES_DIFFER {
+				t.Fatalf("got %+v, want a single %v", diffs, FILES_DIFFER)
+			}
+			if diffs[0].Offset != nil {
+				t.Errorf("got offset %v, want none", *diffs[0].Offset)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package compare
+
+import (
+	"context"
+	"errors"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ThreeWay compares two paths, mine and theirs, against a common base they were both changed from, like diff3. Each
+// item which differs from the base is reported as CHANGED on the side it was changed on, Side being 2 for mine and 3
+// for theirs, as CHANGED_BOTH if both sides changed it the same way or as CONFLICT if they changed it differently. An
+// item counts as changed along with its parent directories, so that deleting a directory on one side and changing a
+// file inside it on the other is a conflict. Path1, Path2 and Path3 are the item in base, mine and theirs. If
+// ReportIdentical is set items unchanged on both sides are reported as IDENTICAL. Brief, Limit, Plan, Stats and
+// Checkpoint are not supported. If ctx is done before the comparison finishes its error is returned.
+func ThreeWay(ctx context.Context, base string, mine string, theirs string, opts Options) ([]Difference, error) {
+	if opts.Brief || opts.Limit > 0 || opts.Plan || opts.Stats != nil || opts.Checkpoint != "" {
+		return nil, errors.New("brief, limit, plan, stats and checkpoints are not supported by three-way comparisons")
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	// Compare both sides against the base, and against each other to tell same changes from conflicting ones.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pairs := [][2]string{{base, mine}, {base, theirs}, {mine, theirs}}
+	var changes [3]map[string]bool
+	var identical []string
+	var incomplete []Difference
+	var errs [3]error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, pair := range pairs {
+		pairOpts := opts
+		pairOpts.ReportIdentical = opts.ReportIdentical && i == 0
+		pairOpts.Result, pairOpts.OnResult = nil, nil
+		diffs, err := Diff(ctx, pair[0], pair[1], pairOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		changes[i] = make(map[string]bool)
+		wg.Add(1)
+		go func(i int, pair [2]string) {
+			defer wg.Done()
+			for d := range diffs {
+				switch {
+				case d.Type == ERROR:
+					errs[i] = errors.New(d.Error)
+				case d.Incomplete():
+					mu.Lock()
+					incomplete = append(incomplete, d)
+					mu.Unlock()
+				case d.Type == IDENTICAL:
+					identical = append(identical, relPath(pair[0], d.Path1))
+				case d.IsDifference():
+					changes[i][itemPath(pair, d)] = true
+				}
+			}
+		}(i, pair)
+	}
+	wg.Wait()
+	if err := errors.Join(errs[:]...); err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	// Items changed in both sides are conflicts unless mine and theirs do not differ there.
+	var dirs [3]map[string]bool
+	for i := range changes {
+		dirs[i] = parents(changes[i])
+	}
+	overlaps := func(rel string, i int) bool {
+		return changes[i][rel] || touched(rel, changes[i]) || dirs[i][rel]
+	}
+	join := func(rel string) (string, string, string) {
+		return below(base, rel), below(mine, rel), below(theirs, rel)
+	}
+	diffs := []Difference{}
+	for side, changed := range changes[:2] {
+		for _, rel := range sortedKeys(changed) {
+			path1, path2, path3 := join(rel)
+			if !overlaps(rel, 1-side) {
+				d := Difference{Type: CHANGED, Path1: path1, Path2: path2, Path3: path3, Side: side + 2}
+				diffs = append(diffs, d)
+			} else if side == 0 && overlaps(rel, 2) {
+				diffs = append(diffs, Difference{Type: CONFLICT, Path1: path1, Path2: path2, Path3: path3})
+			} else if side == 0 {
+				diffs = append(diffs, Difference{Type: CHANGED_BOTH, Path1: path1, Path2: path2, Path3: path3})
+			}
+		}
+	}
+	sort.Strings(identical)
+	for _, rel := range identical {
+		if !overlaps(rel, 1) {
+			path1, path2, path3 := join(rel)
+			diffs = append(diffs, Difference{Type: IDENTICAL, Path1: path1, Path2: path2, Path3: path3})
+		}
+	}
+
+	// Items which could not be compared are reported once, even if they were found by several of the comparisons.
+	seen := make(map[[3]string]bool)
+	for _, d := range incomplete {
+		key := [3]string{d.Type, d.Path1, d.Error}
+		if !seen[key] {
+			seen[key] = true
+			diffs = append(diffs, d)
+		}
+	}
+
+	// The result is that of the three-way comparison, not of the comparisons of pairs it is made of.
+	for _, d := range diffs {
+		if opts.Result != nil {
+			opts.Result.add(d)
+		}
+		if opts.OnResult != nil {
+			opts.OnResult(d)
+		}
+	}
+	return diffs, nil
+}
+
+// itemPath returns the path of the item a difference between a pair of paths is about, relative to them.
+func itemPath(pair [2]string, d Difference) string {
+	switch {
+	case d.Type == ONLY_IN:
+		return relPath(pair[d.Side-1], path.Join(d.Dir, d.Name))
+	case d.Path1 != "":
+		return relPath(pair[0], d.Path1)
+	}
+	return relPath(pair[1], d.Path2)
+}
+
+// below returns the path of rel, a slash separated path relative to a compared path, below it. Paths are joined as
+// when comparing them, with the separator of the operating system unless remote.
+func below(root string, rel string) string {
+	if IsRemote(root) {
+		return path.Join(root, rel)
+	}
+	return filepath.Join(root, rel)
+}
+
+// relPath returns the slash separated path of p relative to root, which p is root itself or below it.
+func relPath(root string, p string) string {
+	root, p = path.Clean(filepath.ToSlash(root)), path.Clean(filepath.ToSlash(p))
+	if root == "." {
+		return p
+	}
+	if p == root {
+		return "."
+	}
+	return strings.TrimPrefix(p, root+"/")
+}
+
+// touched returns whether any parent directory of rel is in paths.
+func touched(rel string, paths map[string]bool) bool {
+	for dir := path.Dir(rel); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if paths[dir] {
+			return true
+		}
+	}
+	return paths["."] && rel != "."
+}
+
+// parents returns the set of all parent directories of the paths in a set, which are the paths with changes below
+// them.
+func parents(paths map[string]bool) map[string]bool {
+	dirs := make(map[string]bool)
+	for p := range paths {
+		if p == "." {
+			continue
+		}
+		for dir := path.Dir(p); !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+			if dir == "." || dir == "/" {
+				break
+			}
+		}
+	}
+	return dirs
+}
+
+// sortedKeys returns the keys of a set in order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,279 @@
+package compare
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Number of bytes sniffed from the start of a file to decide whether it is binary.
+const SNIFF_SIZE = 8000
+
+// Maximum size of files which are diffed line by line, as finding the edits between them takes memory quadratic in the
+// number of lines which differ. Larger files are only reported as differing.
+const UNIFIED_MAX_SIZE = 1024 * 1024
+
+// Classes of contents of files which differ.
+const (
+	BINARY = "binary"
+	TEXT   = "text"
+)
+
+// edit is a single line of an edit script turning one text into another.
+type edit struct {
+	// ' ' for a line common to both texts, '-' for a deleted line and '+' for an inserted line.
+	op   byte
+	line string
+}
+
+// isBinary returns whether data looks like the contents of a binary file, which is the case if it contains a NUL byte
+// within its first SNIFF_SIZE bytes.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), SNIFF_SIZE)], 0) >= 0
+}
+
+// classify returns whether data from the start of a file looks like binary or text contents. Unlike isBinary, data
+// which is not valid UTF-8 is binary as well, except for a character cut off at the end of SNIFF_SIZE bytes.
+func classify(data []byte) string {
+	if isBinary(data) {
+		return BINARY
+	}
+	if len(data) >= SNIFF_SIZE {
+		data = data[:SNIFF_SIZE]
+		for i := 0; i < utf8.UTFMax-1 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	if !utf8.Valid(data) {
+		return BINARY
+	}
+	return TEXT
+}
+
+// content returns the class of the contents of two files, which is BINARY if either of them looks binary. Only their
+// first SNIFF_SIZE bytes are read.
+func (c *comparer) content(file1 string, file2 string) (string, error) {
+	for i, file := range []string{file1, file2} {
+		f, err := c.open(i+1, file)
+		if err != nil {
+			return "", err
+		}
+		data := make([]byte, SNIFF_SIZE)
+		n, err := io.ReadFull(f, data)
+		f.Close()
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", err
+		}
+		if classify(data[:n]) == BINARY {
+			return BINARY, nil
+		}
+	}
+	return TEXT, nil
+}
+
+// sniffer records the first SNIFF_SIZE bytes read from a reader, so that files compared by reading them can be
+// classified without reading them again.
+type sniffer struct {
+	r    io.Reader
+	head []byte
+	eof  bool
+}
+
+func (s *sniffer) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if len(s.head) < SNIFF_SIZE {
+		s.head = append(s.head, p[:min(n, SNIFF_SIZE-len(s.head))]...)
+	}
+	s.eof = s.eof || err == io.EOF
+	return n, err
+}
+
+// sniffed returns the class of the contents of two files from their sniffers, as content does. ok is false if it is
+// not known, as happens if the comparison stopped before reading SNIFF_SIZE bytes of either file.
+func sniffed(s1 *sniffer, s2 *sniffer) (class string, ok bool) {
+	for _, s := range []*sniffer{s1, s2} {
+		if len(s.head) < SNIFF_SIZE && !s.eof {
+			return "", false
+		}
+		if classify(s.head) == BINARY {
+			return BINARY, true
+		}
+	}
+	return TEXT, true
+}
+
+// splitLines splits a text into lines, each keeping its trailing newline if it has one.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes the shortest edit script turning lines a into lines b using Myers' algorithm.
+func diffLines(a []string, b []string) []edit {
+	n, m := len(a), len(b)
+
+	// Texts added or deleted whole, as by patches, only have one edit script, which is found without tracing.
+	if n == 0 || m == 0 {
+		edits := make([]edit, 0, n+m)
+		for _, line := range a {
+			edits = append(edits, edit{'-', line})
+		}
+		for _, line := range b {
+			edits = append(edits, edit{'+', line})
+		}
+		return edits
+	}
+
+	off := n + m + 1
+	v := make([]int, 2*off+1)
+
+	// Find the furthest reaching path for each number of edits d, recording the state before each round. Only the
+	// diagonals which can be reached in a round are recorded to keep memory proportional to the square of d.
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	// Walk back through the recorded states to recover the edits, in reverse.
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			edits = append(edits, edit{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, edit{'+', b[y-1]})
+				y--
+			} else {
+				edits = append(edits, edit{'-', a[x-1]})
+				x--
+			}
+		}
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// hunkRange formats the line range of a hunk as used in its header.
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	} else if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// unified returns a unified diff of two texts with the given number of lines of context around each change.
+func unified(name1 string, name2 string, text1 string, text2 string, context int) string {
+	edits := diffLines(splitLines(text1), splitLines(text2))
+
+	// Number of lines of each text before each edit, used for the hunk headers.
+	pos1 := make([]int, len(edits)+1)
+	pos2 := make([]int, len(edits)+1)
+	for i, e := range edits {
+		pos1[i+1], pos2[i+1] = pos1[i], pos2[i]
+		if e.op != '+' {
+			pos1[i+1]++
+		}
+		if e.op != '-' {
+			pos2[i+1]++
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", name1, name2)
+	for i := 0; i < len(edits); {
+		// Find the next change and extend the hunk over all changes separated by at most twice the context.
+		for i < len(edits) && edits[i].op == ' ' {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+		start := max(i-context, 0)
+		end := i
+		for j := end + 1; j < len(edits) && j-end <= 2*context+1; j++ {
+			if edits[j].op != ' ' {
+				end = j
+			}
+		}
+		stop := min(end+context+1, len(edits))
+
+		fmt.Fprintf(
+			&sb, "@@ -%s +%s @@\n",
+			hunkRange(pos1[start], pos1[stop]-pos1[start]), hunkRange(pos2[start], pos2[stop]-pos2[start]),
+		)
+		for _, e := range edits[start:stop] {
+			sb.WriteByte(e.op)
+			sb.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = stop
+	}
+	return sb.String()
+}
+
+// unifiedFiles returns a unified diff of two text files. ok is false if either file is binary.
+func (c *comparer) unifiedFiles(file1 string, file2 string, context int) (diff string, ok bool, err error) {
+	b1, err := c.readFile(1, file1)
+	if err != nil {
+		return "", false, err
+	}
+	b2, err := c.readFile(2, file2)
+	if err != nil {
+		return "", false, err
+	}
+	if isBinary(b1) || isBinary(b2) {
+		return "", false, nil
+	}
+
+	return unified(c.label(1, file1), c.label(2, file2), string(b1), string(b2), context), true, nil
+}
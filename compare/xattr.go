@@ -0,0 +1,37 @@
+package compare
+
+// diffXattrs compares the extended attributes of two files, returning the difference if any differ. Only local files
+// on filesystems supporting extended attributes are compared.
+func (c *comparer) diffXattrs(file1 string, file2 string) (*Difference, error) {
+	_, local1 := c.fs(1, file1).(osFS)
+	_, local2 := c.fs(2, file2).(osFS)
+	if !local1 || !local2 {
+		return nil, nil
+	}
+	attrs1, ok1, err := xattrs(file1)
+	if err != nil {
+		return nil, err
+	}
+	attrs2, ok2, err := xattrs(file2)
+	if err != nil || !ok1 || !ok2 {
+		return nil, err
+	}
+
+	d := &Difference{
+		Type: XATTR_DIFFER, Path1: file1, Path2: file2, Xattrs1: make(map[string]string), Xattrs2: make(map[string]string),
+	}
+	for name, value := range attrs1 {
+		if value2, ok := attrs2[name]; !ok || value != value2 {
+			d.Xattrs1[name] = value
+		}
+	}
+	for name, value := range attrs2 {
+		if value1, ok := attrs1[name]; !ok || value != value1 {
+			d.Xattrs2[name] = value
+		}
+	}
+	if len(d.Xattrs1) == 0 && len(d.Xattrs2) == 0 {
+		return nil, nil
+	}
+	return d, nil
+}
@@ -0,0 +1,63 @@
+//go:build linux || darwin
+
+package compare
+
+import (
+	"bytes"
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Whether extended attributes can be compared on this platform.
+const xattrSupported = true
+
+// xattrs returns the extended attributes of a local file by name. ok is false if the filesystem it is on does not
+// support them.
+func xattrs(file string) (attrs map[string]string, ok bool, err error) {
+	names, err := getAttr(func(dest []byte) (int, error) { return unix.Listxattr(file, dest) })
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, &os.PathError{Op: "listxattr", Path: file, Err: err}
+	}
+
+	attrs = make(map[string]string)
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := getAttr(func(dest []byte) (int, error) { return unix.Getxattr(file, string(name), dest) })
+		// Attributes removed since they were listed are left out.
+		if errors.Is(err, unix.ENODATA) {
+			continue
+		}
+		if err != nil {
+			return nil, false, &os.PathError{Op: "getxattr", Path: file, Err: err}
+		}
+		attrs[string(name)] = string(value)
+	}
+	return attrs, true, nil
+}
+
+// getAttr calls a function filling dest with an attribute or list of attributes as the xattr syscalls do, first to get
+// the size of the buffer needed and then to fill it. It tries again if the attribute grows in between.
+func getAttr(get func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := get(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		dest := make([]byte, size)
+		n, err := get(dest)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return dest[:n], nil
+	}
+}
@@ -5,176 +5,1248 @@ files are different. If they are directories, it reports differences in contents
 Usage:
 
 	diff [flags] path1 path2
+	some-command | diff [flags] - path2
 
 The flags are:
 
-	-h, --help        Print this help.
-	-r, --recursive   Recursively compare directories.
+	    --allow-missing      Treat a path which does not exist as empty, reporting all of the other as only in it.
+	    --attributes         Also compare Windows attributes such as hidden and readonly of files with equal contents.
+	                         Windows only.
+	-q, --brief              Only report whether the paths differ and stop at the first difference.
+	    --buffer-size        Size in bytes of the buffers used to read files. Defaults to 64 KiB.
+	    --checkpoint         Record compared files to this file, and skip those it already holds when run again.
+	    --color              When to color output, either auto, always or never. Defaults to auto.
+	    --comparator EXT=NAME
+	                         Compare files with this extension using bytes, json, yaml, csv or lines. Can be repeated.
+	    --csv                Compare .csv files by their rows, ignoring the order of columns and rows, see below.
+	    --csv-key            Match rows of .csv files by the values of this column. Can be repeated.
+	    --decompress         Compare .gz, .bz2 and .zst files by their decompressed contents.
+	    --dereference-left   Follow symlinks inside path1 only, comparing what they point to.
+	    --dereference-right  Follow symlinks inside path2 only, comparing what they point to.
+	    --detect-renames     Report files only in one path and identical to files only in the other as renamed.
+	-x, --exclude            Skip entries whose name or relative path matches the pattern. Can be repeated.
+	    --exclude-from       Skip entries matching any pattern in this file, one per line. Can be repeated.
+	    --find-duplicates    Also report groups of files with identical contents anywhere in both directories.
+	-L, --follow-symlinks    Follow symlinks, comparing what they point to instead of their targets.
+	    --format             Output format, either text, json or gnu, see below.
+	    --gitignore          Skip entries ignored by .gitignore files, as well as .git directories.
+	    --hash               Compare files by their digests using sha256, md5 or crc32 instead of byte for byte.
+	-h, --help               Print this help.
+	    --hex                Print a hex dump of the regions where binary files differ.
+	    --hidden             Compare hidden entries, whose names start with a dot, the default. Skip them with =false.
+	    --ignore-blank-lines
+	                         Ignore lines which are empty or only hold whitespace when comparing text files.
+	    --ignore-case        Match file names case insensitively.
+	    --ignore-content-case
+	                         Ignore the case of letters when comparing text files.
+	    --ignore-line-endings
+	                         Treat CRLF and LF line endings as equal when comparing text files.
+	    --ignore-trailing-newline
+	                         Ignore a single newline at the end of text files when comparing them.
+	    --ignore-whitespace  Ignore changes in the amount of whitespace within lines of text files, see below.
+	    --image              Compare .png and .jpeg images which differ pixel by pixel and summarize the changes.
+	    --include            Only compare files whose name or relative path matches the pattern. Can be repeated.
+	-j, --jobs               Maximum number of files to compare in parallel. Defaults to the number of CPUs.
+	    --json-semantic      Compare .json files by their data, ignoring formatting and the order of keys.
+	    --limit N            Stop after N differences have been found, once they are printed.
+	    --lines-set          Compare text files by which lines they have, ignoring their order and repeats.
+	    --list               Only print which files would be compared or skipped, along with the total size to read.
+	    --manifest           Compare a single directory against the manifest in this file, see below.
+	    --max-depth          Maximum depth of subdirectories to recurse into, 0 meaning none. Defaults to unlimited.
+	    --max-size           Skip files larger than this many bytes, reporting them instead, unless their sizes differ.
+	    --min-size           Skip files smaller than this many bytes, even if their sizes differ. Only logged with -v.
+	    --mmap               Compare files by memory mapping them, where supported. Falls back to reading them otherwise.
+	    --mode               Also compare permission bits of files with equal contents.
+	-P, --no-dereference     Compare symlinks by their targets, the default. Overrides the flags following them.
+	    --no-only            Do not print items only present in one of the paths. They still affect the exit status.
+	    --no-parallel        Compare one file at a time in directory order, see below.
+	    --only-in-left       Of the items only present in one of the paths, only print those in path1.
+	    --only-in-right      Of the items only present in one of the paths, only print those in path2.
+	-o, --output             Write the differences to this file instead of stdout.
+	    --owner              Also compare owning user and group ids of files with equal contents. Unix only.
+	    --patch              Print the differences of text files as a patch, to be applied with patch -p1.
+	    --print0             Only print the paths of differences, each followed by a NUL byte, for xargs -0.
+	    --progress           Print the number of files compared and bytes read so far to stderr, if it is a terminal.
+	    --quiet              Print nothing and stop at the first difference. Only the exit status tells the result.
+	-r, --recursive          Recursively compare directories.
+	    --relative           Print paths relative to the compared paths, naming the side of items only in one.
+	-s, --report-identical   Also report files which are identical.
+	    --same-filesystem    Do not recurse into subdirectories on other filesystems, such as mount points. Unix only.
+	    --serve              Also stream differences as server-sent events over HTTP on this address, for example :8080.
+	    --short              Print each difference as a status letter followed by its path, see below.
+	    --similarity         Also print an estimated percentage of similarity of differing files.
+	    --size-only          Consider files equal if their sizes are equal, without reading them.
+	    --sorted             Print the differences in order of their paths, once comparing is done.
+	    --stat-only          Compare files only by size and the metadata asked for, without ever opening them.
+	    --stats              Print counts of entries examined, differences and bytes read at the end.
+	    --summary-json FILE  Write counts of differences, bytes read and the duration as JSON to this file at the end.
+	    --sync-plan          Print the actions which would make path2 match path1, such as copy and delete, see below.
+	    --threads-per-file   Compare files of 64 MiB or more in this many ranges in parallel. Defaults to 1.
+	    --three-way          Compare mine and theirs against base, given as three paths, and report conflicts.
+	    --time               Also compare modification times of files with equal contents.
+	    --time-tolerance     Consider modification times equal if they are within this much of each other, for example 2s.
+	    --timeout            Stop and exit with status 3 if comparing takes longer than this, for example 30s.
+	    --tui                Browse the differences in an interactive terminal UI as they are found, see below.
+	-u, --unified[=N]        Print a unified diff with N lines of context, 3 by default, for differing text files.
+	-v, --verbose            Log paths skipped or which could not be compared to stderr, with the failed operation.
+	    --watch              Compare again whenever either path changes, until interrupted.
+	    --write-manifest     Write the manifest of a single directory to this file, see below.
+	    --xattr              Also compare extended attributes of files with equal contents. Linux and macOS only.
+	    --yaml-semantic      Compare .yaml and .yml files by their data, document by document, ignoring formatting and the
+	                         order of keys.
 
-Diff's reporting is not provided in any specific order and may vary across runs as it parallelizes comparisons.
+Diff's reporting is not provided in any specific order and may vary across runs as it parallelizes comparisons. With
+--sorted the differences are instead collected and printed once comparing is done, in order of their paths relative to
+the compared paths, so that the output is the same across runs.
+
+Differing files are labelled as binary or text, judging by whether their first 8000 bytes contain a NUL byte or invalid
+UTF-8, as in "Files a and b (binary) differ". A pair is binary if either file is. Files compared with --size-only are
+not labelled as their contents are never read. Files found to differ by their sizes alone are not labelled either, and
+show both sizes instead, as in "Files a (1.2 MiB) and b (1.5 MiB) differ in size", exact if they would round the same.
+
+With --max-size N files larger than N bytes are reported as skipped instead of being compared, so that a few huge files
+such as disk images do not dominate the run. Their sizes are still compared from their metadata, so files of different
+sizes are reported as differing as usual.
+
+With --min-size N pairs of files which are both smaller than N bytes are skipped, such as tiny lock files, even if their
+sizes differ, so they never count as differences. Unlike files exceeding --max-size they are not printed, only logged
+with --verbose, and a file which grew to N bytes or more on one side is still compared. Together the two flags compare
+only files within a range of sizes.
+
+With --threads-per-file N files of 64 MiB or more are split into N ranges compared in parallel, each by its own reader,
+which can make comparing a single huge file on fast storage such as an NVMe array faster. The readers come on top of
+those of --jobs. On spinning disks seeking between the ranges is likely slower than reading sequentially, and files
+compared with --mmap are not split.
+
+With --no-parallel everything is compared one item at a time in a single goroutine, the entries of each directory in
+order of their names and subdirectories as they come, ignoring --jobs and --threads-per-file. The differences found are
+the same as when comparing in parallel, but they are always printed in the same order without having to wait for the end
+as with --sorted, which suits test fixtures, and a crash shows a single stack trace. Items only in one of two compared
+directories are printed after those in both.
+
+With --image, PNG and JPEG files which differ, judging by their .png, .jpg or .jpeg extension, are decoded and compared
+pixel by pixel, as in "Files a.png and b.png (binary) differ (12 of 4800 pixels, 0.25%, differ within 4x3 at 10,20)",
+giving the number and percentage of differing pixels and the width, height and top left corner of the smallest box
+holding them. Images of different dimensions are reported with both dimensions instead. Files which cannot be decoded
+are reported with a warning, and like hex dumps images larger than --max-size are not decoded.
+
+With --hex, binary files which differ are followed by a hex dump of the regions around their differences, showing the
+bytes of both files side by side with the differing ones highlighted. Two equal rows of 16 bytes are shown around each
+differing row, skipped rows are marked by a *, and the dump stops after 32 rows so that large files do not flood the
+output.
+
+Exclude patterns use the syntax of Go's path.Match and are matched against both an entry's name and its slash separated
+path relative to the compared directories, so "*.log" skips log files anywhere while "src/vendor" skips only that
+directory. Excluded entries are neither compared nor reported.
+
+With --exclude-from FILE exclude patterns are read from FILE, one per line, and added to those given with --exclude.
+Blank lines and lines starting with # are ignored, and like --exclude it can be repeated.
+
+With --gitignore, entries ignored by the .gitignore files of the compared directories are skipped as if excluded, along
+with .git directories, which is useful when comparing two git checkouts. Each directory's .gitignore applies to
+everything below it, with the usual syntax of git: patterns containing a slash are relative to the directory of the
+file, a trailing slash only matches directories, ** matches any number of directories and a leading ! includes entries
+again. Later patterns take precedence over earlier ones, and patterns of deeper files over those above them. Each path
+is filtered by its own .gitignore files, and other sources of patterns, such as .git/info/exclude, are not read.
+
+Include patterns are matched the same way. If any is given, only files matching one of them are compared, while other
+files are neither compared nor reported. Directories are still compared so that matching files inside them are found.
+An entry matching both an include and an exclude pattern is excluded.
+
+With --hidden=false hidden entries, whose names start with a dot, are skipped as if excluded, along with everything
+inside hidden directories, which declutters comparisons of home directories and project trees. They are skipped before
+include patterns are looked at, so an include pattern such as .env does not bring them back; exclude the hidden entries
+which are not wanted instead. With --gitignore the .gitignore files are still honored, even though being hidden they are
+not compared themselves. The compared paths themselves are never skipped, even if hidden.
+
+If one of two compared directories is empty while the other is not, this is reported as a single item instead of
+listing every entry of the other directory as only in it.
+
+With --max-depth N recursion stops N levels below the compared directories, and deeper common subdirectories are
+reported instead. A depth of 0 compares only the immediate entries. It has no effect without --recursive.
+
+With --same-filesystem, like find -xdev, subdirectories on a different device than the compared directory on their
+side, such as mount points, are not recursed into and are reported instead. This avoids descending into mounted
+volumes when comparing whole trees such as /.
+
+With --ignore-case, names which only differ in case, such as README.md and readme.md, are matched with each other. If
+several entries in the same directory only differ in case, they are matched by their exact names instead.
+
+With --allow-missing one of the compared paths may not exist, as when comparing a tree before and after it was created
+or deleted. The missing path is treated as empty: if the other path is a directory each of its entries is reported as
+only in it, directories once for their whole contents, and if it is a file the file itself is. It still fails if both
+paths are missing, or if the other path is standard input.
+
+With --decompress, files with a .gz, .bz2 or .zst extension are compared by their decompressed contents, against the
+other file decompressed as well if it is compressed too, so that config.json and config.json.gz are equal if the latter
+decompresses to the former. Within directories a compressed file is matched with an entry of the same name without the
+extension, unless the directory also holds a file of that name. Differing files are reported as "Files a and b.gz
+(decompressed) differ at byte N", counting in the decompressed contents, and corrupt compressed data is reported as an
+error reading the file. Decompressing takes precedence over --comparator and the other comparisons of contents.
+
+By default symlinks inside the compared directories are not followed. They are compared by their targets, and a symlink
+is never equal to a file or directory. With --follow-symlinks, symlinks to directories are recursed into and symlinks to
+files are compared by their contents. When following, broken symlinks are reported and skipped, and make the exit
+status 2. If a symlink leads back to a pair of directories already being compared, it is reported as a symlink loop and
+skipped. Symlinks given as path1 or path2 are always followed.
+
+With --dereference-left or --dereference-right symlinks are only followed inside path1 or path2, while those on the
+other side are still compared by their targets. This compares a tree which uses symlinks to share files, such as a
+deployed tree, against one holding real copies of them, such as its source tree. A symlink which is not followed is
+reported as a type mismatch against anything but another symlink.
+
+Special files inside the compared directories, such as named pipes, sockets and devices, are never read, since reading
+them may block forever. They are compared by their kind instead, so that a named pipe only equals another named pipe,
+and devices also by the major and minor device numbers they stand for. Special files given as path1 or path2 are read as
+usual.
+
+With --similarity, differing files are reported along with how similar they are, as the percentage of their bytes found
+in blocks of 256 bytes common to both. It is only an estimate meant for triage, and not a true edit distance.
+
+With --ignore-trailing-newline, text files are compared ignoring a single newline at their end, and with
+--ignore-line-endings CRLF and LF line endings are treated as equal. Files with a NUL byte in their first 8000 bytes are
+binary and always compared byte for byte. As files of different sizes may then be equal, they are always read, and
+--size-only, --hash and --mmap have no effect. Text files which differ are then reported without the byte they differ
+at, as it is only known once they are normalized.
+
+With --ignore-whitespace, whitespace at the start and end of each line of text files is dropped and every other run of
+whitespace is collapsed into a single space before comparing. Whitespace is spaces, tabs, carriage returns, vertical
+tabs and form feeds, so "a  b\t" and " a b" are equal, while newlines are kept so that lines are never joined. With
+--ignore-blank-lines, lines which are empty or only hold such whitespace are dropped. Binary files are handled as above.
+Text files which differ are reported without the byte they differ at, as dropping whitespace and lines moves it.
+
+With --ignore-content-case, letters in text files are compared as if they were all lower case, so that keyword lists and
+config files differing only in case are equal. Letters outside ASCII are lowercased as well, and bytes which are not
+valid UTF-8 only equal themselves. It only applies to files classified as text, while binary files are handled as above,
+and it combines with the other options, so that with --ignore-whitespace too "Foo  Bar" and "foo bar" are equal. As
+lowercasing a letter outside ASCII may change its length in bytes, text files which differ are reported without the byte
+they differ at. Note that --ignore-case is unrelated and only matches file names.
+
+With --unified, differing text files are printed as a unified diff instead of their first differing byte. Files with a
+NUL byte in their first 8000 bytes are treated as binary and only reported as differing, as are files larger than 1 MiB,
+since diffing them could take a lot of memory.
+
+With --patch, the output is a patch which can be applied inside the first path with patch -p1 to turn it into the
+second. Differing text files are printed as unified diffs with 3 lines of context unless --unified says otherwise,
+naming the files by their relative paths prefixed by a/ and b/, and text files only present in one path are added or
+deleted whole against /dev/null. Binary files, differing files larger than 1 MiB, symlinks and entries of archives
+cannot be patched and are left out, with any such difference noted on stderr instead.
+
+If both paths are zip or tar archives, detected by their .zip, .tar, .tar.gz or .tgz extension or their contents, they
+are compared entry by entry as if they were directories. Tar archives may be gzip compressed, and the contents of their
+files are read into memory as they cannot be read out of order. Entries are reported with the path of the archive
+followed by their path inside it, such as a.zip/dir/file.
+
+With --xattr files with equal contents are also compared by their extended attributes, as set by setfattr or xattr, to
+check that a backup preserved them, as in Files a and b differ in xattrs (user.origin: web vs none), listing each
+attribute which differs with its value on either side, or none where it is missing. Values which are not printable text
+are quoted. Like --owner it composes with --mode and --time and only applies to local files, and files on filesystems
+without extended attributes are not compared by them. It is only supported on Linux and macOS.
+
+With --attributes files with equal contents are also compared by their readonly, hidden and system attributes, as set by
+attrib, as in Files a and b differ in attributes (hidden vs none), listing the attributes of either file or none. Other
+attributes, such as archive which Windows sets whenever a file is written, are not compared. Like --owner it composes
+with --mode and --time and only applies to local files. It is only supported on Windows.
+
+On Windows local paths are printed with backslashes, as given to and returned by the system, while paths relative to the
+compared paths, such as those printed with --relative or listed by --sync-plan, and remote paths always use forward
+slashes. Directory junctions are treated like symbolic links to directories: they are compared by their targets, and
+recursed into with --follow-symlinks. Creating symbolic links needs the right privilege or developer mode, which
+junctions do not, so a tree copied without it may hold junctions or copies where the original held symbolic links, and
+differ by them.
+
+With --stat-only files are compared by their metadata alone and never opened, which is the fastest way to audit two
+trees. Their sizes are always compared, as with --size-only, and their permission bits, modification times and owners
+only if --mode, --time and --owner are given. Everything which needs the contents of files, such as --hash, --unified,
+--patch, --detect-renames and the text and semantic comparisons, has no effect, and neither has --max-size.
+
+With --find-duplicates, once the directories have been compared, every file below both of them is hashed with SHA-256
+and each group of files with identical contents is reported, wherever they are, which helps to deduplicate the trees. A
+group of a single file in each directory at the same relative path is left out, as it is just an unchanged file, and so
+are empty files. Groups are listed with up to 10 files in text output, with the rest only counted, while json lists them
+all. Duplicates do not count as differences for the exit status.
+
+With --list the paths are walked as usual but files are not compared. Instead each pair of files which would be
+compared is printed with their sizes, as is each entry skipped by --exclude or --include, followed by the number of
+files and bytes a comparison would read. This is useful to check patterns before a long comparison.
+
+If one path is a directory and the other a file, the file is compared against the file of the same name inside the
+directory, as GNU diff does. For example diff a b/config compares a/config and b/config.
+
+A path of - reads from standard input, which is compared byte for byte against the other path. Only one of the paths can
+be -, and the other must be a file.
+
+A path of the form [user@]host:path is read from a remote host over SFTP, connecting with SSH on port 22. As with scp a
+path is only remote if the colon comes before any slash, so ./a:b is a local path. Authentication uses the SSH agent and
+the default unencrypted keys in ~/.ssh, and host keys must be listed in ~/.ssh/known_hosts. Symlink loops are detected,
+but --mmap, --owner and hard link detection only apply to local files, and standard input cannot be compared against a
+remote path.
+
+With --three-way three paths are given, a base and two versions changed from it, mine and theirs, like diff3. Each item
+changed from the base is reported as changed in one of the versions, changed the same way in both, or as a conflict if
+both changed it differently. A directory added or deleted on one side conflicts with changes to items inside it on the
+other. Unchanged files are only reported with --report-identical, and --brief, --limit, --list and --stats cannot be
+used.
+
+With --json-semantic, files with a .json extension on both sides are parsed and compared by the data they hold, so that
+reformatting and reordering object keys do not count as differences. Differing files are reported with the JSON paths of
+the values which differ, such as $.items[0].name. A file which is not valid JSON is reported with a warning and compared
+byte for byte instead.
+
+With --yaml-semantic, files with a .yaml or .yml extension on both sides are compared the same way, after resolving
+anchors and aliases. Files with several documents are compared document by document, and the paths of their differences
+are prefixed by the number of the document, such as 2:$.metadata.name.
+
+With --csv, files with a .csv extension on both sides are parsed and compared by their rows, taking the first record as
+the header, so that reordering columns or rows does not count as a difference. Rows are matched by the values of the
+columns given with --csv-key, which can be repeated for a compound key, or by all their values if no key is given.
+Differing files are followed by the columns only present in either header, then a line for each row removed, marked by
+-, added, marked by +, or changed, marked by ~ along with the columns whose values differ, such as ~ id=5 (price). At
+most 100 rows of each kind are listed. A file which cannot be parsed or lacks a key column is reported with a warning
+and compared byte for byte instead.
+
+With --lines-set text files are compared as sets of lines, like comm on their sorted and deduplicated lines, so that the
+order of lines and how often they appear do not matter, as for unsorted log exports or word lists. The lines only in
+either file are listed below the files, marked by < for path1 and > for path2, up to 100 of each. Only the digests of
+the lines of the first file are kept in memory while both files are streamed. Binary files are noted and compared byte
+for byte instead, and files compared by their data with --json-semantic, --yaml-semantic or --csv are compared that way.
+
+With --comparator EXT=NAME files with the extension EXT on both sides are compared with the comparator NAME, one of
+bytes, json, yaml, csv or lines, regardless of the flags enabling them for other files. For example --comparator
+log=lines compares .log files as sets of lines and --comparator json=bytes compares .json files byte for byte despite
+--json-semantic. Extensions are matched case insensitively, with or without their leading dot. Files whose extensions
+map to different comparators are compared byte for byte.
+
+With --serve ADDR the differences are also streamed to HTTP clients as they are found, as server-sent events at /events,
+each holding a difference as in the json format. Clients connecting late first receive the differences found so far, and
+a done event is sent once the comparison finishes. The server keeps running until interrupted with Ctrl-C, after which
+diff exits with the usual status.
+
+With --write-manifest FILE a single directory is given, and the SHA-256 digest of every file below it is written to
+FILE, one line per file holding the hex encoded digest, a space and the path of the file relative to the directory. With
+--manifest FILE the directory is instead compared against such a manifest, so that a tree can be checked against a known
+good state without the tree it was made from, for example in CI. Files in the manifest but not in the directory are
+reported as only in the manifest, files not in the manifest as only in the directory, and files whose digests differ as
+differing. Both recurse into all subdirectories and honor --exclude and --include, while symlinks are only followed
+with --follow-symlinks.
+
+With --checkpoint FILE each pair of files is recorded in FILE once compared, one JSON line per pair holding its paths
+and the differences found, so that a long comparison which is interrupted can be resumed by running the same command
+again. Pairs already in the file are not compared again, and their differences are printed as recorded, so files changed
+since they were recorded are not noticed. Pairs are found by their paths, so the order in which they are compared does
+not matter, while pairs which could not be read are compared again. Delete FILE to start over. Checkpoints cannot be
+used with --three-way and are ignored with --list.
+
+With --limit N comparing stops once N differences have been found, and only those are printed along with any warnings
+before them. Unlike --brief the differing paths are printed, which is useful to spot-check large trees. As files are
+compared in parallel, which differences are found first can vary between runs.
+
+With --verbose each item which was skipped or could not be compared, such as an unreadable file, a broken symlink or a
+file exceeding --max-size, is logged to stderr along with the operation which failed, instead of being printed with the
+differences. This keeps diagnostics apart from results when piping the output. In json mode such items are still
+included in the document. The exit status is not affected.
+
+With --watch the paths are compared again whenever a file or directory below either of them changes, and the current
+differences are printed each time, after clearing the screen if the output is a terminal. Changes are noticed through
+the notifications of the operating system, and a burst of changes within 300 milliseconds of each other causes a single
+comparison. It keeps running until interrupted, or until --timeout if given, and only local paths can be watched.
+
+With --stats the number of files and directories examined in each directory is printed at the end, the entries present
+on either side which are not left out, and then in total, so that no differences because nothing was compared can be
+told apart from no differences because everything matched. A one line summary of counts follows, then the number of
+bytes read and the rate they were read at, which shows whether the comparison is bound by I/O and how --jobs affects it.
+In json mode it is printed to stderr so that the output remains a valid document.
+
+With --summary-json FILE the totals of --stats are written to FILE as a single JSON object once comparing is done,
+without the individual differences, even with --quiet: the number of pairs of files compared, how many of them differ,
+the numbers of entries only in path1 or path2 and of type mismatches, the bytes read and the duration of the comparison
+in seconds, as compared, differ, only_in1, only_in2, type_mismatches, bytes and seconds.
+
+With --short each difference is printed on one line as a status letter followed by its path, like git status --short: M
+for files which differ in contents or metadata, < and > for items only in path1 or path2, T for type mismatches, R for
+renames followed by both paths, = for identical files and ! for items which could not be compared. Paths are those
+printed by --print0. Other warnings are printed to stderr so that the output stays easy to grep, and combined with
+--sorted it is stable across runs.
+
+With --sync-plan the differences are printed once comparing is done as the actions which would make path2 match path1,
+taken as the source of truth, with paths relative to the compared directories: "copy X" for entries only in path1,
+"delete X" for entries only in path2, "update X" for files which differ in contents or metadata, "delete X" followed by
+"copy X" for type mismatches, and "move Y to X" for renames found with --detect-renames. Directories only in one path
+are copied or deleted whole, and an empty directory is filled with or emptied of the contents of the other. Actions are
+printed in the order they are to be taken: deletions first, entries below a directory before the directory itself, then
+moves, then copies and updates, directories before the entries below them. Nothing is changed, the plan is only printed.
+Archives are compared as files, since they are copied whole, and items the plan does not cover, such as files which
+could not be compared or common subdirectories left uncompared without -r, are noted on stderr.
+
+With --only-in-left only the items only present in path1 are printed of those present in one of the paths, which answers
+what is missing from path2 when it is the target of a sync, and with --only-in-right only those only present in path2,
+such as files a sync would delete. Files in both paths are still compared and printed as usual, and as with --no-only
+the items left out still affect the exit status. With --sync-plan the actions for the items left out are left out as
+well.
+
+With --format json the differences are printed once comparing is done as a single array of objects, each holding the
+type of a difference along with the fields which apply to it, so that tools can categorize them. Files which differ hold
+their sizes in bytes as size1 and size2, except when one of them is read from stdin, as that stops at the first
+difference, or checked against a manifest, which only holds digests. If they were compared byte for byte they also hold
+the offset of the first differing byte as offset, which is also the number of bytes which matched before it. Text
+compared with --ignore-trailing-newline or the other options normalizing it has no offset, as it is only known in the
+normalized text. Those found to differ by their sizes alone, without reading them, have by_size set instead, and the
+number of matching bytes is not known.
+
+With --format gnu differences are printed phrased exactly as by GNU diff -rq, without colors, as in Files a and b
+differ, Only in dir: name, Common subdirectories: a and b, File a is a regular file while file b is a directory and
+Symbolic links a and b differ, so that scripts parsing the output of GNU diff keep working. Diffs asked for with
+--unified are printed as usual. Remaining divergences: items GNU diff has no message for, such as differences in mode,
+time or owner, renames, devices which differ and warnings, are printed to stderr in the text format and still affect the
+exit status; a directory which is empty while the other is not is noted that way instead of listing every entry only in
+the other; paths are cleaned, so a trailing slash given on the command line is not repeated; empty files are called
+regular files, where GNU diff calls them regular empty files; and differences are printed in the order they are found
+unless --sorted is given.
+
+With --tui the differences are shown in an interactive terminal UI as they are found instead of being printed, listed in
+a tree by their paths relative to the compared paths and colored red for differences, magenta for items which could not
+be compared and yellow for warnings. The arrow keys move through the tree and Enter expands or collapses a directory,
+while the pane beside it shows the details of the current entry, such as the unified diff of text files or the hex dump
+of binary files, or the number of differences below a directory. Tab switches to the details to scroll them and back,
+and q or Esc quits, stopping the comparison if it is not done yet, in which case diff exits with status 2. The status
+line shows the progress of the comparison. Output must go to a terminal, and --stats is printed once the UI is closed.
+
+With --relative every path is printed relative to the compared path of its side, so that the output of comparing the
+same trees in different places is the same and can itself be compared. Items only in one side are printed as Only in
+path1: sub/name or Only in path2: sub/name to tell the sides apart, while --short and json keep telling them apart by
+their status letter and side. Diffs printed with --unified keep their full paths.
+
+With --print0 only the path of each difference is printed, followed by a NUL byte, so that the output can be safely
+piped to xargs -0. For items present in both paths it is the path in path1. Colors are never used.
+
+With --color=auto, the default, output is colored unless the NO_COLOR environment variable is set, stdout is not a
+terminal or the output is written to a file with --output. --color=always always colors output, for example when piping
+into less -R, and --color=never never does. The older --no-color flag is deprecated and the same as --color=never.
+
+Files and directories which cannot be read due to missing permissions are reported and skipped, and the rest of the
+paths are still compared. So are files whose contents fail to be read, for example due to a bad sector on a disk. Both
+make the exit status 2.
+
+The exit status is 0 if the paths are identical, 1 if differences were found, 2 if an error occurred and 3 if the
+timeout was exceeded. Differences found anywhere below the compared paths count, as do items which could not be compared
+in --brief mode, where they are not printed.
+
+The comparison itself is implemented by the compare package, which can be used as a library. Its Diff function streams
+differences over a channel as they are found, which is how they are printed here.
 */
 package main
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path"
-	"sync"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/fatih/color"
+	"github.com/samiksome92/diff/compare"
 	"github.com/spf13/pflag"
 )
 
-// Number of bytes to read at once from a file.
-const CHUNK_SIZE = 4 * 1024
+// How often the progress is updated with --progress.
+const PROGRESS_INTERVAL = 200 * time.Millisecond
+
+// Maximum number of files of a group of duplicates listed in text output, after which the rest are only counted.
+const DUPLICATES_SHOWN = 10
 
-var wg sync.WaitGroup
 var red = color.New(color.FgHiRed).SprintFunc()
 var yellow = color.New(color.FgHiYellow).SprintFunc()
 var magenta = color.New(color.FgHiMagenta).SprintFunc()
+var green = color.New(color.FgHiGreen).SprintFunc()
 
-// checkErr checks for a non nil error and exits the program after logging it.
+// checkErr checks for a non nil error and exits the program with status 2 after logging it, or status 3 if the error
+// is due to the timeout being exceeded.
 func checkErr(err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Print("Timeout exceeded.")
+		os.Exit(3)
+	}
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		os.Exit(2)
+	}
+}
+
+// text returns the human readable line for a difference.
+func text(d compare.Difference) string {
+	switch d.Type {
+	case compare.FILES_DIFFER:
+		if d.Diff != "" {
+			return strings.TrimSuffix(d.Diff, "\n")
+		}
+		s := fmt.Sprintf("Files %v and %v", d.Path1, d.Path2)
+		// Files which differ in size show both sizes, which tells which one grew. Sizes which round the same are exact.
+		if size1, size2 := d.Size1, d.Size2; d.BySize {
+			s1, s2 := formatBytes(*size1), formatBytes(*size2)
+			if s1 == s2 {
+				s1, s2 = fmt.Sprintf("%d B", *size1), fmt.Sprintf("%d B", *size2)
+			}
+			s = fmt.Sprintf("Files %v (%v) and %v (%v)", d.Path1, s1, d.Path2, s2)
+		} else if d.Content != "" {
+			s += fmt.Sprintf(" (%v)", d.Content)
+		}
+		if d.Decompressed {
+			s += " (decompressed)"
+		}
+		s += " " + red("differ")
+		if d.BySize {
+			s += " in size"
+		}
+		if d.Offset != nil {
+			s += fmt.Sprintf(" at byte %v", *d.Offset)
+		}
+		if len(d.Paths) > 0 {
+			s += fmt.Sprintf(" at %v", strings.Join(d.Paths, ", "))
+		}
+		if d.Similarity != nil {
+			s += fmt.Sprintf(" (%v%% similar)", *d.Similarity)
+		}
+		if i := d.Image; i != nil && (i.Width1 != i.Width2 || i.Height1 != i.Height2) {
+			s += fmt.Sprintf(" (dimensions %vx%v vs %vx%v)", i.Width1, i.Height1, i.Width2, i.Height2)
+		} else if i != nil && i.Pixels == 0 {
+			s += " (all pixels equal)"
+		} else if i != nil {
+			s += fmt.Sprintf(
+				" (%v of %v pixels, %.2f%%, differ within %vx%v at %v,%v)", i.Pixels, i.Width1*i.Height1, i.Percent,
+				i.Right-i.Left, i.Bottom-i.Top, i.Left, i.Top,
+			)
+		}
+		if len(d.Hex) > 0 {
+			s += "\n" + hexDump(d)
+		}
+		if d.CSV != nil {
+			s += "\n" + csvChanges(d)
+		}
+		if d.Lines != nil {
+			s += "\n" + lineChanges(d)
+		}
+		return s
+	case compare.ONLY_IN:
+		return fmt.Sprintf("%s %v: %v", yellow("Only in"), d.Dir, d.Name)
+	case compare.TYPE_MISMATCH:
+		return fmt.Sprintf("%v is a %s while %v is a %s", d.Path1, magenta(d.Kind1), d.Path2, magenta(d.Kind2))
+	case compare.COMMON_SUBDIR:
+		return fmt.Sprintf("Common subdirectories: %v and %v", d.Path1, d.Path2)
+	case compare.MODE_DIFFER:
+		return fmt.Sprintf("Files %v and %v %s (%v vs %v)", d.Path1, d.Path2, red("differ in mode"), d.Mode1, d.Mode2)
+	case compare.TIME_DIFFER:
+		return fmt.Sprintf("Files %v and %v %s (%v vs %v)", d.Path1, d.Path2, red("differ in mtime"), d.Time1, d.Time2)
+	case compare.OWNER_DIFFER:
+		return fmt.Sprintf("Files %v and %v %s (%v vs %v)", d.Path1, d.Path2, red("differ in owner"), d.Owner1, d.Owner2)
+	case compare.ATTRIBUTES_DIFFER:
+		return fmt.Sprintf(
+			"Files %v and %v %s (%v vs %v)", d.Path1, d.Path2, red("differ in attributes"), d.Attributes1, d.Attributes2,
+		)
+	case compare.XATTR_DIFFER:
+		return fmt.Sprintf("Files %v and %v %s (%v)", d.Path1, d.Path2, red("differ in xattrs"), xattrChanges(d))
+	case compare.EMPTY_DIR:
+		empty, other := d.Path1, d.Path2
+		if d.Side == 2 {
+			empty, other = other, empty
+		}
+		return fmt.Sprintf("Directory %v %s while %v has %v entries", empty, yellow("is empty"), other, d.Entries)
+	case compare.PLANNED:
+		return fmt.Sprintf(
+			"Would compare %v and %v (%v and %v)", d.Path1, d.Path2, formatBytes(*d.Size1), formatBytes(*d.Size2),
+		)
+	case compare.SKIPPED:
+		return fmt.Sprintf("%s %v", yellow("Skipping"), d.Path1)
+	case compare.RENAMED:
+		return fmt.Sprintf("%s: %v -> %v", yellow("Renamed"), d.Path1, d.Path2)
+	case compare.IDENTICAL:
+		if d.Path3 != "" {
+			return fmt.Sprintf("Files %v, %v and %v are identical", d.Path1, d.Path2, d.Path3)
+		}
+		return fmt.Sprintf("Files %v and %v are identical", d.Path1, d.Path2)
+	case compare.CHANGED:
+		changed := d.Path2
+		if d.Side == 3 {
+			changed = d.Path3
+		}
+		return fmt.Sprintf("%s: %v -> %v", yellow("Changed"), d.Path1, changed)
+	case compare.CHANGED_BOTH:
+		return fmt.Sprintf("%s: %v -> %v and %v", yellow("Changed in both"), d.Path1, d.Path2, d.Path3)
+	case compare.CONFLICT:
+		return fmt.Sprintf("%s: %v changed differently in %v and %v", red("Conflict"), d.Path1, d.Path2, d.Path3)
+	case compare.BROKEN_SYMLINK:
+		return fmt.Sprintf("%s: %v", magenta("Broken symlink"), d.Path1)
+	case compare.PERMISSION_DENIED:
+		return fmt.Sprintf("%s %v: %v", magenta("Cannot read"), d.Path1, d.Error)
+	case compare.READ_ERROR:
+		return fmt.Sprintf("%s %v: %v", magenta("Error reading"), d.Path1, d.Error)
+	case compare.LINKS_DIFFER:
+		return fmt.Sprintf(
+			"Symlinks %v and %v %s (target %v vs %v)", d.Path1, d.Path2, red("differ"), d.Target1, d.Target2,
+		)
+	case compare.DEVICES_DIFFER:
+		return fmt.Sprintf(
+			"Devices %v and %v %s (%v %v vs %v)", d.Path1, d.Path2, red("differ"), d.Kind1, d.Device1, d.Device2,
+		)
+	case compare.INVALID_JSON:
+		return fmt.Sprintf("%s %v: %v, comparing it byte for byte", yellow("Invalid JSON"), d.Path1, d.Error)
+	case compare.INVALID_YAML:
+		return fmt.Sprintf("%s %v: %v, comparing it byte for byte", yellow("Invalid YAML"), d.Path1, d.Error)
+	case compare.INVALID_CSV:
+		return fmt.Sprintf("%s %v: %v, comparing it byte for byte", yellow("Invalid CSV"), d.Path1, d.Error)
+	case compare.BINARY_FILE:
+		return fmt.Sprintf("%s %v, comparing it byte for byte", yellow("Binary file"), d.Path1)
+	case compare.INVALID_IMAGE:
+		return fmt.Sprintf("%s %v: %v, not comparing its pixels", yellow("Invalid image"), d.Path1, d.Error)
+	case compare.TOO_LARGE:
+		return fmt.Sprintf("%s %v and %v (exceeds max size)", yellow("Skipped"), d.Path1, d.Path2)
+	case compare.TOO_SMALL:
+		return fmt.Sprintf("%s %v and %v (below min size)", yellow("Skipped"), d.Path1, d.Path2)
+	case compare.MOUNT_POINT:
+		return fmt.Sprintf("%s: not comparing %v and %v", yellow("Mount point"), d.Path1, d.Path2)
+	case compare.DUPLICATES:
+		s := fmt.Sprintf("%s in %v files:", yellow("Duplicate contents"), len(d.Duplicates))
+		for _, f := range d.Duplicates[:min(len(d.Duplicates), DUPLICATES_SHOWN)] {
+			s += "\n  " + f
+		}
+		if n := len(d.Duplicates) - DUPLICATES_SHOWN; n > 0 {
+			s += fmt.Sprintf("\n  ... and %v more", n)
+		}
+		return s
+	case compare.SYMLINK_LOOP:
+		return fmt.Sprintf("%s: %v and %v are already being compared", yellow("Symlink loop"), d.Path1, d.Path2)
 	}
+	return ""
 }
 
-// cmpFiles compares two files byte for byte and returns whether they are equal or not.
-func cmpFiles(file1 string, file2 string) bool {
-	// Open both files and get their stats.
-	f1, err := os.Open(file1)
-	checkErr(err)
-	defer f1.Close()
+// diagnostic returns the line logged with --verbose for an item which was skipped or could not be compared, along with
+// the operation which failed if known. It is empty for any other item.
+func diagnostic(d compare.Difference) string {
+	switch d.Type {
+	case compare.PERMISSION_DENIED, compare.READ_ERROR:
+		if d.Op != "" {
+			return fmt.Sprintf("Skipping %v: %v failed: %v", d.Path1, d.Op, d.Error)
+		}
+		return fmt.Sprintf("Skipping %v: %v", d.Path1, d.Error)
+	case compare.BROKEN_SYMLINK:
+		return fmt.Sprintf("Skipping %v: broken symlink", d.Path1)
+	case compare.TOO_LARGE:
+		return fmt.Sprintf("Skipping %v and %v: exceeds max size", d.Path1, d.Path2)
+	case compare.TOO_SMALL:
+		return fmt.Sprintf("Skipping %v and %v: below min size", d.Path1, d.Path2)
+	case compare.MOUNT_POINT:
+		return fmt.Sprintf("Skipping %v and %v: mount point", d.Path1, d.Path2)
+	case compare.SYMLINK_LOOP:
+		if d.Path2 == "" {
+			return fmt.Sprintf("Skipping %v: symlink loop", d.Path1)
+		}
+		return fmt.Sprintf("Skipping %v and %v: symlink loop", d.Path1, d.Path2)
+	}
+	return ""
+}
 
-	stat1, err := f1.Stat()
-	checkErr(err)
+// hexDump formats the hex dump of a difference as rows of the offset followed by the bytes of each file side by side,
+// with the bytes which differ highlighted. Skipped regions are marked by a * like in hexdump.
+func hexDump(d compare.Difference) string {
+	var lines []string
+	next := d.Hex[0].Offset
+	for _, row := range d.Hex {
+		if row.Offset != next {
+			lines = append(lines, "*")
+		}
+		next = row.Offset + compare.HEX_ROW_SIZE
+		line := fmt.Sprintf("%08x  %s  %s", row.Offset, hexBytes(row.Hex1, row.Hex2), hexBytes(row.Hex2, row.Hex1))
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+	if d.HexTruncated {
+		lines = append(lines, "...")
+	}
+	return strings.Join(lines, "\n")
+}
 
-	f2, err := os.Open(file2)
-	checkErr(err)
-	defer f2.Close()
+// csvChanges formats how two CSV files differ as the columns only in either file followed by a line for each row only
+// in the first file, marked by -, only in the second, marked by +, or changed, marked by ~ and followed by the columns
+// which differ.
+func csvChanges(d compare.Difference) string {
+	var lines []string
+	if len(d.CSV.Columns1) > 0 {
+		lines = append(lines, fmt.Sprintf("  columns only in %v: %v", d.Path1, strings.Join(d.CSV.Columns1, ", ")))
+	}
+	if len(d.CSV.Columns2) > 0 {
+		lines = append(lines, fmt.Sprintf("  columns only in %v: %v", d.Path2, strings.Join(d.CSV.Columns2, ", ")))
+	}
+	for _, row := range d.CSV.Removed {
+		lines = append(lines, red("  - "+row))
+	}
+	for _, row := range d.CSV.Added {
+		lines = append(lines, green("  + "+row))
+	}
+	for _, change := range d.CSV.Changed {
+		line := yellow("  ~ " + change.Key)
+		if len(change.Columns) > 0 {
+			line += fmt.Sprintf(" (%v)", strings.Join(change.Columns, ", "))
+		}
+		lines = append(lines, line)
+	}
+	if d.CSV.Truncated {
+		lines = append(lines, "  ...")
+	}
+	return strings.Join(lines, "\n")
+}
 
-	stat2, err := f2.Stat()
-	checkErr(err)
+// lineChanges formats the lines only in either of two text files compared as sets of lines, marked by < for the first
+// file and > for the second, like diff.
+func lineChanges(d compare.Difference) string {
+	var lines []string
+	for _, line := range d.Lines.Lines1 {
+		lines = append(lines, red("  < "+line))
+	}
+	for _, line := range d.Lines.Lines2 {
+		lines = append(lines, green("  > "+line))
+	}
+	if d.Lines.Truncated {
+		lines = append(lines, "  ...")
+	}
+	return strings.Join(lines, "\n")
+}
 
-	// If files have different sizes they cannot be same.
-	if stat1.Size() != stat2.Size() {
-		return false
+// xattrChanges formats the extended attributes which differ between two files as name: value vs value, in order of
+// their names. Missing attributes are shown as none, and values which are not printable text are quoted.
+func xattrChanges(d compare.Difference) string {
+	var names []string
+	for name := range d.Xattrs1 {
+		names = append(names, name)
+	}
+	for name := range d.Xattrs2 {
+		if _, ok := d.Xattrs1[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	value := func(attrs map[string]string, name string) string {
+		v, ok := attrs[name]
+		if !ok {
+			return "none"
+		}
+		if !utf8.ValidString(v) || strings.IndexFunc(v, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+			return strconv.Quote(v)
+		}
+		return v
 	}
 
-	// Read bytes in chunks and compare them.
-	b1 := make([]byte, CHUNK_SIZE)
-	b2 := make([]byte, CHUNK_SIZE)
-	for {
-		n1, err1 := f1.Read(b1)
-		n2, err2 := f2.Read(b2)
+	var changes []string
+	for _, name := range names {
+		changes = append(changes, fmt.Sprintf("%v: %v vs %v", name, value(d.Xattrs1, name), value(d.Xattrs2, name)))
+	}
+	return strings.Join(changes, ", ")
+}
 
-		// If both files end at the same time they are the same, otherwise they are different.
-		if err1 == io.EOF && err2 == io.EOF {
-			return true
-		} else if err1 == io.EOF && err2 == nil {
-			return false
-		} else if err1 == nil && err2 == io.EOF {
-			return false
-		} else if err1 != nil || err2 != nil {
-			log.Fatal(err1, err2)
+// hexBytes formats the hex encoded bytes of a row of a hex dump separated by spaces and padded to a full row, with the
+// bytes which are not the same in the other row highlighted.
+func hexBytes(h string, other string) string {
+	var b strings.Builder
+	for i := 0; i < compare.HEX_ROW_SIZE; i++ {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if 2*i >= len(h) {
+			b.WriteString("  ")
+		} else if 2*i >= len(other) || h[2*i:2*i+2] != other[2*i:2*i+2] {
+			b.WriteString(red(h[2*i : 2*i+2]))
+		} else {
+			b.WriteString(h[2*i : 2*i+2])
 		}
+	}
+	return b.String()
+}
 
-		// If number of bytes read are not same files are different.
-		if n1 != n2 {
-			return false
+// diffPath returns the single path printed for a difference with --print0, which is the path on the first side for
+// items present on both and the path of the item otherwise. Items which are not differences have no path.
+func diffPath(d compare.Difference) string {
+	if !d.IsDifference() {
+		return ""
+	}
+	if d.Type == compare.ONLY_IN {
+		return onlyPath(d)
+	}
+	return d.Path1
+}
+
+// onlyPath returns the path of an item only present on one side, joined from its directory and name as comparing joins
+// paths: with the separator of the operating system unless it is remote.
+func onlyPath(d compare.Difference) string {
+	if compare.IsRemote(d.Dir) {
+		return path.Join(d.Dir, d.Name)
+	}
+	return filepath.Join(d.Dir, d.Name)
+}
+
+// short returns the line for a difference in the short format, a status letter followed by the path printed with
+// --print0, or by both paths for renames. Differences without a status letter return an empty string.
+func short(d compare.Difference) string {
+	switch d.Type {
+	case compare.FILES_DIFFER, compare.MODE_DIFFER, compare.TIME_DIFFER, compare.OWNER_DIFFER, compare.XATTR_DIFFER,
+		compare.ATTRIBUTES_DIFFER, compare.LINKS_DIFFER, compare.DEVICES_DIFFER, compare.EMPTY_DIR, compare.CHANGED,
+		compare.CHANGED_BOTH:
+		return red("M") + " " + d.Path1
+	case compare.ONLY_IN:
+		if d.Side == 1 {
+			return yellow("<") + " " + onlyPath(d)
 		}
+		return yellow(">") + " " + onlyPath(d)
+	case compare.TYPE_MISMATCH:
+		return magenta("T") + " " + d.Path1
+	case compare.RENAMED:
+		return yellow("R") + " " + d.Path1 + " -> " + d.Path2
+	case compare.CONFLICT:
+		return red("C") + " " + d.Path1
+	case compare.IDENTICAL:
+		return "= " + d.Path1
+	}
+	if d.Incomplete() {
+		return magenta("!") + " " + d.Path1
+	}
+	return ""
+}
+
+// gnuKinds are the names GNU diff gives to the kinds of entries in type mismatches.
+var gnuKinds = map[string]string{
+	"file":             "regular file",
+	"symlink":          "symbolic link",
+	"named pipe":       "fifo",
+	"character device": "character special file",
+	"block device":     "block special file",
+}
 
-		if n1 < CHUNK_SIZE {
-			b1 = b1[:CHUNK_SIZE]
-			b2 = b2[:CHUNK_SIZE]
+// gnu returns the line printed for a difference in gnu format, phrased exactly as GNU diff does, or an empty string for
+// items GNU diff has no message for.
+func gnu(d compare.Difference) string {
+	switch d.Type {
+	case compare.FILES_DIFFER:
+		if d.Diff != "" {
+			return strings.TrimSuffix(d.Diff, "\n")
 		}
+		return fmt.Sprintf("Files %v and %v differ", d.Path1, d.Path2)
+	case compare.ONLY_IN:
+		return fmt.Sprintf("Only in %v: %v", d.Dir, d.Name)
+	case compare.COMMON_SUBDIR:
+		return fmt.Sprintf("Common subdirectories: %v and %v", d.Path1, d.Path2)
+	case compare.TYPE_MISMATCH:
+		kind1, kind2 := d.Kind1, d.Kind2
+		if k, ok := gnuKinds[kind1]; ok {
+			kind1 = k
+		}
+		if k, ok := gnuKinds[kind2]; ok {
+			kind2 = k
+		}
+		return fmt.Sprintf("File %v is a %v while file %v is a %v", d.Path1, kind1, d.Path2, kind2)
+	case compare.LINKS_DIFFER:
+		return fmt.Sprintf("Symbolic links %v and %v differ", d.Path1, d.Path2)
+	case compare.IDENTICAL:
+		return fmt.Sprintf("Files %v and %v are identical", d.Path1, d.Path2)
+	}
+	return ""
+}
+
+// relative returns the path p relative to root if it is below it, or p otherwise, with forward slashes.
+func relative(root string, p string) string {
+	root, p = path.Clean(filepath.ToSlash(root)), path.Clean(filepath.ToSlash(p))
+	if p == root {
+		return "."
+	}
+	if root == "." {
+		return p
+	}
+	if rel, ok := strings.CutPrefix(p, root+"/"); ok {
+		return rel
+	}
+	return p
+}
 
-		// If all bytes are not same files are different.
-		if !bytes.Equal(b1, b2) {
-			return false
+// relativize returns a difference with its paths made relative to roots, the compared paths of their sides, so that
+// ONLY_IN items have a relative Dir. A path which is not below the root of its side, as for items which could not be
+// read, which may be on either side, is made relative to whichever other root it is below.
+func relativize(d compare.Difference, roots []string) compare.Difference {
+	rel := func(side int, p string) string {
+		if p == "" {
+			return p
 		}
+		for _, root := range []string{roots[side-1], roots[0], roots[1]} {
+			if r := relative(root, p); r != path.Clean(filepath.ToSlash(p)) || path.Clean(root) == "." {
+				return r
+			}
+		}
+		return p
+	}
+
+	d.Path1, d.Path2, d.Path3 = rel(1, d.Path1), rel(2, d.Path2), rel(3, d.Path3)
+	if d.Duplicates != nil {
+		files := make([]string, len(d.Duplicates))
+		for i, f := range d.Duplicates {
+			files[i] = rel(1, f)
+		}
+		d.Duplicates = files
+	}
+	if d.Type == compare.ONLY_IN {
+		d.Dir = rel(d.Side, d.Dir)
 	}
+	return d
 }
 
-// diffFiles compares two files and outputs whether they are different. Should be called via a goroutine.
-func diffFiles(file1 string, file2 string) {
-	if !cmpFiles(file1, file2) {
-		fmt.Printf("Files %v and %v %s\n", file1, file2, red("differ"))
+// sortKey returns the path of the item a difference is about relative to the compared path of its side, by which
+// differences are sorted.
+func sortKey(d compare.Difference, roots []string) string {
+	switch {
+	case d.Type == compare.ONLY_IN:
+		return relative(roots[d.Side-1], path.Join(d.Dir, d.Name))
+	case d.Type == compare.DUPLICATES:
+		return relative(roots[0], d.Duplicates[0])
+	case d.Path1 != "":
+		return relative(roots[0], d.Path1)
 	}
+	return relative(roots[1], d.Path2)
+}
 
-	wg.Done()
+// printer writes differences to a writer as they are received, in the output format asked for. Only one of print0,
+// json, gnu, patch, short and syncPlan is set. Nothing is written for each difference if silent is set, as in brief or
+// quiet mode. If sorted is set the differences are collected and written by finish in order of their paths relative to
+// roots, the compared paths, instead. If relative is set paths are printed relative to roots, and items only present on
+// one side name the side by the position of its path. If onlyIn is 1 or 2 items only present on the other side are not
+// printed. If verbose is set items which were skipped or could not be
+// compared are logged to stderr as they are received instead, except that json documents still include them.
+type printer struct {
+	w        io.Writer
+	json     bool
+	gnu      bool
+	print0   bool
+	patch    bool
+	short    bool
+	syncPlan bool
+	noOnly   bool
+	onlyIn   int
+	silent   bool
+	sorted   bool
+	verbose  bool
+	relative bool
+	roots    []string
+	all      []compare.Difference
 }
 
-// diffDirs compares two directories (recursively if specified) and outputs which items are different. Should be called
-// via a goroutine.
-func diffDirs(dir1 string, dir2 string, recursive bool) {
-	// Read directories.
-	files1, err := os.ReadDir(dir1)
-	checkErr(err)
-	files2, err := os.ReadDir(dir2)
-	checkErr(err)
+// newPrinter returns a printer writing to w, or to stdout if w is nil.
+func newPrinter(w io.Writer) *printer {
+	if w == nil {
+		w = os.Stdout
+	}
+	return &printer{w: w}
+}
 
-	// Creates maps for tracking which files have been checked.
-	type d struct {
-		e fs.DirEntry
-		c bool
+// print writes a single difference. In json, sorted and sync plan mode it is collected instead, to be written by
+// finish.
+func (p *printer) print(d compare.Difference) {
+	if p.relative {
+		d = relativize(d, p.roots)
+	}
+	if s := diagnostic(d); p.verbose && s != "" {
+		log.Print(s)
+		if !p.json {
+			return
+		}
 	}
-	fileSet1 := make(map[string]d)
-	for _, f := range files1 {
-		fileSet1[f.Name()] = d{f, false}
+	// Items only present on one side still count as differences, they are just not printed. Files below the minimum
+	// size are left out as if filtered, so they are only logged when verbose.
+	if p.silent || (d.Type == compare.ONLY_IN && (p.noOnly || (p.onlyIn != 0 && d.Side != p.onlyIn))) ||
+		(d.Type == compare.TOO_SMALL && !p.json) {
+		return
 	}
-	fileSet2 := make(map[string]d)
-	for _, f := range files2 {
-		fileSet2[f.Name()] = d{f, false}
+	if p.json || p.sorted || p.syncPlan {
+		p.all = append(p.all, d)
+		return
 	}
+	p.write(d)
+}
 
-	// Iterate through contents first directory.
-	for _, f := range files1 {
-		name := f.Name()
-		f2, ok := fileSet2[name]
+// write writes a single difference in the output format.
+func (p *printer) write(d compare.Difference) {
+	if p.print0 {
+		if s := diffPath(d); s != "" {
+			fmt.Fprint(p.w, s, "\x00")
+		}
+	} else if p.short {
+		// Differences without a status letter, such as warnings, are noted on stderr to keep the output greppable.
+		if s := short(d); s != "" {
+			fmt.Fprintln(p.w, s)
+		} else if d.Type != compare.COMMON_SUBDIR {
+			fmt.Fprintln(os.Stderr, text(d))
+		}
+	} else if p.gnu {
+		// Items GNU diff has no message for are noted on stderr, so that the output parses like that of GNU diff.
+		if s := gnu(d); s != "" {
+			fmt.Fprintln(p.w, s)
+		} else {
+			fmt.Fprintln(os.Stderr, text(d))
+		}
+	} else if p.patch {
+		// Only diffs go into the patch, differences which cannot be patched are noted on stderr.
+		if d.Diff != "" {
+			fmt.Fprint(p.w, d.Diff)
+		} else if d.IsDifference() || d.Incomplete() {
+			fmt.Fprintln(os.Stderr, text(d))
+		}
+	} else if p.relative && d.Type == compare.ONLY_IN {
+		// Relative directories do not tell the sides apart, so the side is named instead.
+		fmt.Fprintf(p.w, "%s path%v: %v\n", yellow("Only in"), d.Side, onlyPath(d))
+	} else {
+		fmt.Fprintln(p.w, text(d))
+	}
+}
 
-		// If item is present in second directory, compare them if possible.
-		if ok {
-			path1 := path.Join(dir1, name)
-			path2 := path.Join(dir2, name)
-			if !f.IsDir() && !f2.e.IsDir() {
-				wg.Add(1)
-				go diffFiles(path1, path2)
-			} else if f.IsDir() && f2.e.IsDir() {
-				if recursive {
-					wg.Add(1)
-					go diffDirs(path1, path2, true)
-				} else {
-					fmt.Printf("Common subdirectories: %v and %v\n", path1, path2)
-				}
-			} else if f.IsDir() && !f2.e.IsDir() {
-				fmt.Printf("%v is a %s while %v is a %s\n", path1, magenta("directory"), path2, magenta("file"))
-			} else {
-				fmt.Printf("%v is a %s while %v is a %s\n", path1, magenta("file"), path2, magenta("directory"))
+// finish writes the differences collected in sorted mode in order, those collected in json mode as a single document
+// and those collected in sync plan mode as the actions they call for. Differences about the same path are ordered by
+// their type and side, so that the order never varies.
+func (p *printer) finish() error {
+	if p.silent {
+		return nil
+	}
+	if p.syncPlan {
+		// Items the plan does not cover, such as files which could not be compared, are noted on stderr.
+		actions, skipped := syncPlan(p.all, p.roots)
+		for _, d := range skipped {
+			fmt.Fprintln(os.Stderr, text(d))
+		}
+		for _, a := range actions {
+			fmt.Fprintln(p.w, a)
+		}
+		return nil
+	}
+	if p.sorted {
+		keys := make([]string, len(p.all))
+		order := make([]int, len(p.all))
+		for i, d := range p.all {
+			keys[i], order[i] = sortKey(d, p.roots), i
+		}
+		sort.Slice(order, func(i, j int) bool {
+			a, b := order[i], order[j]
+			if keys[a] != keys[b] {
+				return keys[a] < keys[b]
 			}
+			if p.all[a].Type != p.all[b].Type {
+				return p.all[a].Type < p.all[b].Type
+			}
+			return p.all[a].Side < p.all[b].Side
+		})
+		all := make([]compare.Difference, len(order))
+		for i, j := range order {
+			all[i] = p.all[j]
+		}
+		p.all = all
+	}
+	if !p.json {
+		for _, d := range p.all {
+			p.write(d)
+		}
+		return nil
+	}
+	all := p.all
+	if all == nil {
+		all = []compare.Difference{}
+	}
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(all)
+}
 
-			f2.c = true
-			fileSet2[name] = f2
-		} else {
-			fmt.Printf("%s %v: %v\n", yellow("Only in"), dir1, name)
+// isTerminal returns whether a file is a terminal.
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// showProgress prints the progress to stderr in place every PROGRESS_INTERVAL until done is closed, after which the
+// final progress is printed on its own line and stopped is closed.
+func showProgress(p *compare.Progress, done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	show := func() {
+		fmt.Fprintf(os.Stderr, "\r%v files compared, %v read", p.Files.Load(), formatBytes(p.Bytes.Load()))
+	}
+
+	ticker := time.NewTicker(PROGRESS_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			show()
+		case <-done:
+			show()
+			fmt.Fprintln(os.Stderr)
+			return
 		}
 	}
+}
+
+// formatBytes formats a number of bytes using binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// throughput describes n bytes read in the elapsed time, along with the rate they were read at.
+func throughput(n int64, elapsed time.Duration) string {
+	rate := "-"
+	if elapsed > 0 {
+		rate = formatBytes(int64(float64(n)/elapsed.Seconds())) + "/s"
+	}
+	return fmt.Sprintf("Read %v in %v (%v)", formatBytes(n), elapsed.Round(time.Millisecond), rate)
+}
+
+// summary is the summary written with --summary-json, the totals of a comparison without its individual differences.
+type summary struct {
+	Compared       int64 `json:"compared"`
+	Differ         int64 `json:"differ"`
+	OnlyIn1        int64 `json:"only_in1"`
+	OnlyIn2        int64 `json:"only_in2"`
+	TypeMismatches int64 `json:"type_mismatches"`
+	Bytes          int64 `json:"bytes"`
+	// Duration of the comparison in seconds.
+	Seconds float64 `json:"seconds"`
+}
+
+// writeSummary writes the summary of a comparison which took the elapsed time to a file as JSON.
+func writeSummary(name string, s *compare.Stats, p *compare.Progress, elapsed time.Duration) error {
+	data, err := json.MarshalIndent(summary{
+		Compared:       p.Files.Load(),
+		Differ:         s.FilesDiffer.Load(),
+		OnlyIn1:        s.OnlyIn1.Load(),
+		OnlyIn2:        s.OnlyIn2.Load(),
+		TypeMismatches: s.TypeMismatches.Load(),
+		Bytes:          s.Bytes.Load(),
+		Seconds:        elapsed.Seconds(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0o644)
+}
+
+// diffStdin compares standard input against a file. If first is true the file is the first path, otherwise standard
+// input is. The differences are sent on the returned channel once the comparison is done.
+func diffStdin(ctx context.Context, file string, first bool, opts compare.Options) (<-chan compare.Difference, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 
-	// All non-checked items in second directory are only present in that directory.
-	for _, f := range files2 {
-		name := f.Name()
+	var diffs []compare.Difference
+	if first {
+		diffs, err = compare.DiffReaders(ctx, file, f, "-", os.Stdin, opts)
+	} else {
+		diffs, err = compare.DiffReaders(ctx, "-", os.Stdin, file, f, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toChannel(diffs), nil
+}
 
-		if !fileSet2[name].c {
-			fmt.Printf("%s %v: %v\n", yellow("Only in"), dir2, name)
+// diffThreeWay compares mine and theirs against base. The differences are sent on the returned channel once the
+// comparison is done.
+func diffThreeWay(
+	ctx context.Context, base string, mine string, theirs string, opts compare.Options,
+) (<-chan compare.Difference, error) {
+	diffs, err := compare.ThreeWay(ctx, base, mine, theirs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return toChannel(diffs), nil
+}
+
+// diffManifest compares a directory against the manifest in a file. The differences are sent on the returned channel
+// once the comparison is done.
+func diffManifest(
+	ctx context.Context, file string, dir string, opts compare.Options,
+) (<-chan compare.Difference, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	manifest, err := compare.ReadManifest(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest %v: %w", file, err)
+	}
+
+	diffs, err := compare.DiffManifest(ctx, file, manifest, dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	return toChannel(diffs), nil
+}
+
+// writeManifest writes the manifest of a directory to a file.
+func writeManifest(ctx context.Context, file string, dir string, opts compare.Options) error {
+	manifest, err := compare.Manifest(ctx, dir, opts)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := compare.WriteManifest(f, manifest); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readPatterns reads patterns from a file, one per line. Blank lines and lines starting with # are ignored.
+func readPatterns(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
+		patterns = append(patterns, line)
 	}
+	return patterns, nil
+}
 
-	wg.Done()
+// toChannel returns a closed channel holding all the differences.
+func toChannel(diffs []compare.Difference) <-chan compare.Difference {
+	ch := make(chan compare.Difference, len(diffs))
+	for _, d := range diffs {
+		ch <- d
+	}
+	close(ch)
+	return ch
 }
 
 func main() {
@@ -183,32 +1255,606 @@ func main() {
 	// Define and parse arguments.
 	help := pflag.BoolP("help", "h", false, "Print this help.")
 	recursive := pflag.BoolP("recursive", "r", false, "Recursively compare directories.")
+	brief := pflag.BoolP("brief", "q", false, "Only report whether the paths differ and stop at the first difference.")
+	list := pflag.Bool("list", false, "Only print which files would be compared or skipped, without comparing them.")
+	limit := pflag.Int("limit", 0, "Stop after this many differences have been found.")
+	verbose := pflag.BoolP(
+		"verbose", "v", false, "Log paths skipped or which could not be compared to stderr, with the failed operation.",
+	)
+	quiet := pflag.Bool("quiet", false, "Print nothing, only exit with the status, and stop at the first difference.")
+	format := pflag.String("format", "text", "Output format, either text, json or gnu.")
+	jobs := pflag.IntP("jobs", "j", runtime.NumCPU(), "Maximum number of files to compare in parallel.")
+	noParallel := pflag.Bool("no-parallel", false, "Compare one file at a time in directory order, see below.")
+	exclude := pflag.StringArrayP("exclude", "x", nil, "Skip entries whose name or relative path matches the pattern.")
+	hidden := pflag.Bool("hidden", true, "Compare hidden entries, whose names start with a dot. Skip them with =false.")
+	gitignore := pflag.Bool("gitignore", false, "Skip entries ignored by .gitignore files, and .git directories.")
+	excludeFrom := pflag.StringArray("exclude-from", nil, "Skip entries matching any pattern in this file, one per line.")
+	include := pflag.StringArray("include", nil, "Only compare files whose name or relative path matches the pattern.")
+	mode := pflag.Bool("mode", false, "Also compare permission bits of files with equal contents.")
+	owner := pflag.Bool("owner", false, "Also compare owning user and group ids of files with equal contents.")
+	xattr := pflag.Bool("xattr", false, "Also compare extended attributes of files with equal contents.")
+	attributes := pflag.Bool(
+		"attributes", false, "Also compare Windows attributes such as hidden and readonly of files with equal contents.",
+	)
+	comparatorFlag := pflag.StringArray("comparator", nil, "Compare files with this extension using this comparator.")
+	linesSet := pflag.Bool("lines-set", false, "Compare text files by which lines they have, ignoring their order.")
+	csvFlag := pflag.Bool("csv", false, "Compare .csv files by their rows, ignoring the order of columns and rows.")
+	csvKeys := pflag.StringArray("csv-key", nil, "Match rows of .csv files by the values of this column.")
+	jsonSemantic := pflag.Bool(
+		"json-semantic", false, "Compare .json files by their data, ignoring formatting and the order of keys.",
+	)
+	serve := pflag.String("serve", "", "Also stream differences as server-sent events over HTTP on this address.")
+	minSize := pflag.Int64("min-size", 0, "Skip files smaller than this many bytes, even if their sizes differ.")
+	maxSize := pflag.Int64("max-size", 0, "Skip files larger than this many bytes, unless their sizes differ.")
+	threeWay := pflag.Bool("three-way", false, "Compare mine and theirs against base, given as three paths.")
+	imageFlag := pflag.Bool("image", false, "Compare .png and .jpeg images which differ pixel by pixel.")
+	hexFlag := pflag.Bool("hex", false, "Print a hex dump of the regions where binary files differ.")
+	yamlSemantic := pflag.Bool(
+		"yaml-semantic", false, "Compare .yaml and .yml files by their data, ignoring formatting and the order of keys.",
+	)
+	sameFilesystem := pflag.Bool(
+		"same-filesystem", false, "Do not recurse into subdirectories on other filesystems, such as mount points.",
+	)
+	mtime := pflag.Bool("time", false, "Also compare modification times of files with equal contents.")
+	timeTolerance := pflag.Duration(
+		"time-tolerance", 0, "Consider modification times equal if they are within this much of each other.",
+	)
+	statOnly := pflag.Bool(
+		"stat-only", false, "Compare files only by size and the metadata asked for, without ever opening them.",
+	)
+	sizeOnly := pflag.Bool("size-only", false, "Consider files equal if their sizes are equal, without reading them.")
+	hash := pflag.String("hash", "", "Compare files by their digests using sha256, md5 or crc32.")
+	maxDepth := pflag.Int("max-depth", -1, "Maximum depth of subdirectories to recurse into, 0 meaning none.")
+	reportIdentical := pflag.BoolP("report-identical", "s", false, "Also report files which are identical.")
+	followSymlinks := pflag.BoolP(
+		"follow-symlinks", "L", false, "Follow symlinks, comparing what they point to instead of their targets.",
+	)
+	dereferenceLeft := pflag.Bool(
+		"dereference-left", false, "Follow symlinks inside path1 only, comparing what they point to.",
+	)
+	dereferenceRight := pflag.Bool(
+		"dereference-right", false, "Follow symlinks inside path2 only, comparing what they point to.",
+	)
+	noDereference := pflag.BoolP(
+		"no-dereference", "P", false, "Compare symlinks by their targets, the default. Overrides the flags following them.",
+	)
+	bufferSize := pflag.Int("buffer-size", compare.BUFFER_SIZE, "Size in bytes of the buffers used to read files.")
+	mmap := pflag.Bool("mmap", false, "Compare files by memory mapping them, where supported.")
+	threadsPerFile := pflag.Int("threads-per-file", 1, "Compare files of 64 MiB or more in this many parallel ranges.")
+	unified := pflag.IntP("unified", "u", -1, "Print a unified diff with this many lines of context for text files.")
+	pflag.Lookup("unified").NoOptDefVal = "3"
+	patch := pflag.Bool("patch", false, "Print the differences of text files as a patch for patch -p1.")
+	manifest := pflag.String("manifest", "", "Compare a single directory against the manifest in this file.")
+	writeManifestTo := pflag.String("write-manifest", "", "Write the manifest of a single directory to this file.")
+	shortFlag := pflag.Bool("short", false, "Print each difference as a status letter followed by its path.")
+	sorted := pflag.Bool("sorted", false, "Print the differences in order of their paths once comparing is done.")
+	syncPlanFlag := pflag.Bool(
+		"sync-plan", false, "Print the actions which would make path2 match path1, such as copy and delete, see below.",
+	)
+	checkpoint := pflag.String("checkpoint", "", "Record compared files to this file, and skip those it already holds.")
+	watch := pflag.Bool("watch", false, "Compare again whenever either path changes, until interrupted.")
+	tui := pflag.Bool("tui", false, "Browse the differences in an interactive terminal UI as they are found.")
+	timeout := pflag.Duration("timeout", 0, "Stop and exit with status 3 if comparing takes longer than this.")
+	stats := pflag.Bool("stats", false, "Print counts of entries examined, differences and bytes read at the end.")
+	summaryJSON := pflag.String(
+		"summary-json", "", "Write counts of differences, bytes read and the duration as JSON to this file at the end.",
+	)
+	progress := pflag.Bool("progress", false, "Print the number of files compared and bytes read so far to stderr.")
+	similarity := pflag.Bool("similarity", false, "Also print an estimated percentage of similarity of differing files.")
+	ignoreCase := pflag.Bool("ignore-case", false, "Match file names case insensitively.")
+	allowMissing := pflag.Bool(
+		"allow-missing", false, "Treat a path which does not exist as empty, reporting all of the other as only in it.",
+	)
+	decompress := pflag.Bool("decompress", false, "Compare .gz, .bz2 and .zst files by their decompressed contents.")
+	ignoreWhitespace := pflag.Bool(
+		"ignore-whitespace", false, "Ignore changes in the amount of whitespace within lines of text files.",
+	)
+	ignoreBlankLines := pflag.Bool("ignore-blank-lines", false, "Ignore blank lines when comparing text files.")
+	ignoreContentCase := pflag.Bool(
+		"ignore-content-case", false, "Ignore the case of letters when comparing text files.",
+	)
+	ignoreLineEndings := pflag.Bool(
+		"ignore-line-endings", false, "Treat CRLF and LF line endings as equal when comparing text files.",
+	)
+	ignoreTrailingNewline := pflag.Bool(
+		"ignore-trailing-newline", false, "Ignore a single newline at the end of text files when comparing them.",
+	)
+	colorMode := pflag.String("color", "auto", "When to color output, either auto, always or never.")
+	noColor := pflag.Bool("no-color", false, "Disable colored output.")
+	pflag.CommandLine.MarkDeprecated("no-color", "use --color=never instead")
+	findDuplicates := pflag.Bool(
+		"find-duplicates", false, "Also report groups of files with identical contents anywhere in both directories.",
+	)
+	relativeFlag := pflag.Bool("relative", false, "Print paths relative to the compared paths.")
+	print0 := pflag.Bool("print0", false, "Only print the paths of differences, each followed by a NUL byte.")
+	output := pflag.StringP("output", "o", "", "Write the differences to this file instead of stdout.")
+	noOnly := pflag.Bool("no-only", false, "Do not print items only present in one of the paths.")
+	onlyInLeft := pflag.Bool(
+		"only-in-left", false, "Of the items only present in one of the paths, only print those in path1.",
+	)
+	onlyInRight := pflag.Bool(
+		"only-in-right", false, "Of the items only present in one of the paths, only print those in path2.",
+	)
+	detectRenames := pflag.Bool(
+		"detect-renames", false, "Report files only in one path and identical to files only in the other as renamed.",
+	)
 	pflag.Parse()
 
 	// Print help if requested or if wrong number of arguments are provided.
-	if *help || len(pflag.Args()) != 2 {
+	args := 2
+	if *threeWay {
+		args = 3
+	} else if *manifest != "" || *writeManifestTo != "" {
+		args = 1
+	}
+	if *help || len(pflag.Args()) != args {
 		fmt.Println("Usage: diff [flags] path1 path2")
+		fmt.Println("       diff --three-way [flags] base mine theirs")
+		fmt.Println("       diff --manifest FILE [flags] dir")
+		fmt.Println("       diff --write-manifest FILE [flags] dir")
 		pflag.PrintDefaults()
+		if *help {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+	// In auto mode colors are already disabled if stdout is not a terminal or NO_COLOR is set.
+	if *noColor {
+		*colorMode = "never"
+	}
+	switch *colorMode {
+	case "auto":
+	case "always":
+		color.NoColor = false
+	case "never":
+		color.NoColor = true
+	default:
+		log.Printf("Invalid color mode: %v", *colorMode)
+		os.Exit(2)
+	}
+	if *format != "text" && *format != "json" && *format != "gnu" {
+		log.Printf("Invalid format: %v", *format)
+		os.Exit(2)
+	}
+	if *print0 && *format != "text" {
+		log.Print("Cannot use --print0 with --format json or gnu.")
+		os.Exit(2)
+	}
+	if *patch && (*print0 || *format != "text" || *threeWay) {
+		log.Print("Cannot use --patch with --print0, --format json or gnu, or --three-way.")
+		os.Exit(2)
+	}
+	if *shortFlag && (*print0 || *format != "text" || *patch || *list) {
+		log.Print("Cannot use --short with --print0, --format json or gnu, --patch or --list.")
+		os.Exit(2)
+	}
+	// Patches have the usual 3 lines of context unless asked otherwise.
+	if *patch && *unified < 0 {
+		*unified = 3
+	}
+	// Patches are read back by patch, so they are not colored.
+	if *patch {
+		color.NoColor = true
+	}
+	// Paths are printed as they are, without colors, so that they can be read back. Likewise for GNU diff output.
+	if *print0 || *format == "gnu" {
+		color.NoColor = true
+	}
+	if *format == "gnu" && *threeWay {
+		log.Print("Cannot use --format gnu with --three-way.")
+		os.Exit(2)
+	}
+	if (*onlyInLeft || *onlyInRight) && ((*onlyInLeft && *onlyInRight) || *noOnly || *threeWay) {
+		log.Print("Cannot use --only-in-left or --only-in-right with each other, --no-only or --three-way.")
+		os.Exit(2)
+	}
+	if *syncPlanFlag && (*print0 || *format != "text" || *patch || *shortFlag || *list) {
+		log.Print("Cannot use --sync-plan with --print0, --format json or gnu, --patch, --short or --list.")
+		os.Exit(2)
+	}
+	if *syncPlanFlag && (*threeWay || *manifest != "" || *writeManifestTo != "" || *tui) {
+		log.Print("Cannot use --sync-plan with --three-way, manifests or --tui.")
+		os.Exit(2)
+	}
+
+	// A manifest takes the place of the first path, and a manifest is written from the first path.
+	if (*manifest != "" || *writeManifestTo != "") && (*threeWay || *list || pflag.Args()[0] == "-") {
+		log.Print("Cannot use manifests with --three-way, --list or standard input.")
+		os.Exit(2)
+	}
+	if *manifest != "" && *writeManifestTo != "" {
+		log.Print("Cannot use --manifest with --write-manifest.")
+		os.Exit(2)
+	}
+	var path1, path2 string
+	switch {
+	case *manifest != "":
+		path1, path2 = *manifest, pflag.Args()[0]
+	case *writeManifestTo != "":
+		path1, path2 = pflag.Args()[0], *writeManifestTo
+	default:
+		path1, path2 = pflag.Args()[0], pflag.Args()[1]
+	}
+	if path1 == "-" && path2 == "-" {
+		log.Print("Only one path can be standard input.")
+		os.Exit(2)
+	}
+	// Watching compares the paths over and over, so only local paths can be watched and only their differences printed.
+	if *watch && *allowMissing {
+		log.Print("Cannot use --watch with --allow-missing.")
+		os.Exit(2)
+	}
+	if *watch && (path1 == "-" || path2 == "-" || compare.IsRemote(path1) || compare.IsRemote(path2)) {
+		log.Print("Cannot watch standard input or remote paths.")
+		os.Exit(2)
+	}
+	if *watch && (*threeWay || *manifest != "" || *writeManifestTo != "" || *list || *brief || *quiet) {
+		log.Print("Cannot use --watch with --three-way, manifests, --list, --brief or --quiet.")
+		os.Exit(2)
+	}
+	if *watch && (*stats || *summaryJSON != "" || *progress || *checkpoint != "" || *serve != "") {
+		log.Print("Cannot use --watch with --stats, --summary-json, --progress, --checkpoint or --serve.")
+		os.Exit(2)
+	}
+	// The TUI takes over the terminal, so nothing else may print to it.
+	if *tui && (*output != "" || !isTerminal(os.Stdout)) {
+		log.Print("Cannot use --tui unless printing to a terminal.")
+		os.Exit(2)
+	}
+	if *tui && (*watch || *serve != "" || *list || *brief || *quiet || *progress || *verbose) {
+		log.Print("Cannot use --tui with --watch, --serve, --list, --brief, --quiet, --progress or --verbose.")
+		os.Exit(2)
+	}
+	if *tui && (*format != "text" || *print0 || *patch || *shortFlag) {
+		log.Print("Cannot use --tui with --format json or gnu, --print0, --patch or --short.")
+		os.Exit(2)
+	}
+	if *list && (path1 == "-" || path2 == "-") {
+		log.Print("Cannot list the comparison of standard input.")
+		os.Exit(2)
+	}
+	var path3 string
+	if *threeWay {
+		path3 = pflag.Args()[2]
+		if path1 == "-" || path2 == "-" || path3 == "-" {
+			log.Print("Cannot compare standard input three-way.")
+			os.Exit(2)
+		}
+		if *dereferenceLeft || *dereferenceRight || *findDuplicates {
+			log.Print("Cannot use --dereference-left, --dereference-right or --find-duplicates with --three-way.")
+			os.Exit(2)
+		}
+		if *brief || *limit > 0 || *list || *stats || *summaryJSON != "" {
+			log.Print("Cannot use --brief, --limit, --list, --stats or --summary-json with --three-way.")
+			os.Exit(2)
+		}
+	}
+
+	// Ensure path1 and path2 are either both files or both directories and act accordingly. A path of - is standard
+	// input and is compared as a file. Remote paths are checked once connected to their host.
+	// With --allow-missing a path which does not exist is left unset, to be treated as empty by the comparison, unless
+	// it is compared against standard input.
+	var stat1, stat2 os.FileInfo
+	var err error
+	noStat := *threeWay || *manifest != "" || *writeManifestTo != ""
+	missing := func(err error, other string) bool {
+		return *allowMissing && errors.Is(err, fs.ErrNotExist) && other != "-"
+	}
+	if path1 != "-" && !compare.IsRemote(path1) && !noStat {
+		if stat1, err = os.Stat(path1); !missing(err, path2) {
+			checkErr(err)
+		}
+	}
+	if path2 != "-" && !compare.IsRemote(path2) && !noStat {
+		if stat2, err = os.Stat(path2); !missing(err, path1) {
+			checkErr(err)
+		}
+	}
+
+	// Like GNU diff, a file compared against a directory is compared against the file of the same name inside it.
+	if stat1 != nil && stat2 != nil && stat1.IsDir() != stat2.IsDir() {
+		if stat1.IsDir() {
+			path1 = filepath.Join(path1, filepath.Base(path2))
+			stat1, err = os.Stat(path1)
+		} else {
+			path2 = filepath.Join(path2, filepath.Base(path1))
+			stat2, err = os.Stat(path2)
+		}
+		checkErr(err)
+	}
+	// A sync plan is made of the entries of two directories.
+	if *syncPlanFlag && (path1 == "-" || path2 == "-" || stat1 != nil && !stat1.IsDir()) {
+		log.Print("Cannot plan a sync unless comparing two directories.")
+		os.Exit(2)
+	}
+	// Patterns read from files are added to those given inline.
+	for _, file := range *excludeFrom {
+		patterns, err := readPatterns(file)
+		checkErr(err)
+		*exclude = append(*exclude, patterns...)
+	}
+	comparators := make(map[string]string, len(*comparatorFlag))
+	for _, c := range *comparatorFlag {
+		ext, name, ok := strings.Cut(c, "=")
+		if !ok || ext == "" {
+			log.Printf("Invalid comparator: %v", c)
+			os.Exit(2)
+		}
+		comparators[ext] = name
+	}
+	opts := compare.Options{
+		Recursive:             *recursive && *maxDepth != 0,
+		MaxDepth:              max(*maxDepth, 0),
+		Brief:                 *brief || *quiet,
+		Limit:                 *limit,
+		Jobs:                  *jobs,
+		Sequential:            *noParallel,
+		BufferSize:            *bufferSize,
+		Mmap:                  *mmap,
+		ThreadsPerFile:        *threadsPerFile,
+		Unified:               *unified >= 0,
+		Context:               *unified,
+		Patch:                 *patch,
+		Similarity:            *similarity,
+		Exclude:               *exclude,
+		Include:               *include,
+		Gitignore:             *gitignore,
+		NoHidden:              !*hidden,
+		Mode:                  *mode,
+		Time:                  *mtime,
+		Owner:                 *owner,
+		Xattr:                 *xattr,
+		Attributes:            *attributes,
+		JSONSemantic:          *jsonSemantic,
+		MaxSize:               *maxSize,
+		MinSize:               *minSize,
+		Hex:                   *hexFlag,
+		Image:                 *imageFlag,
+		YAMLSemantic:          *yamlSemantic,
+		CSV:                   *csvFlag,
+		LinesSet:              *linesSet,
+		Comparators:           comparators,
+		CSVKeys:               *csvKeys,
+		SameFilesystem:        *sameFilesystem,
+		TimeTolerance:         *timeTolerance,
+		SizeOnly:              *sizeOnly,
+		StatOnly:              *statOnly,
+		NoArchives:            *syncPlanFlag,
+		Hash:                  *hash,
+		DetectRenames:         *detectRenames,
+		IgnoreCase:            *ignoreCase,
+		AllowMissing:          *allowMissing,
+		Decompress:            *decompress,
+		IgnoreTrailingNewline: *ignoreTrailingNewline,
+		IgnoreLineEndings:     *ignoreLineEndings,
+		IgnoreWhitespace:      *ignoreWhitespace,
+		IgnoreBlankLines:      *ignoreBlankLines,
+		IgnoreContentCase:     *ignoreContentCase,
+		Stats:                 &compare.Stats{},
+		Progress:              &compare.Progress{},
+		Result:                &compare.Result{},
+		Plan:                  *list,
+		ReportIdentical:       *reportIdentical,
+		Checkpoint:            *checkpoint,
+		FollowSymlinks:        *followSymlinks && !*noDereference,
+		FollowSymlinks1:       *dereferenceLeft && !*noDereference,
+		FollowSymlinks2:       *dereferenceRight && !*noDereference,
+		FindDuplicates:        *findDuplicates,
+	}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	// Quitting the TUI before the comparison is done stops it.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	// The details of differences are shown in the TUI, without colors as it colors them itself.
+	if *tui {
+		if !opts.Unified {
+			opts.Unified, opts.Context = true, 3
+		}
+		opts.Hex = true
+		color.NoColor = true
+	}
+
+	// Differences go to stdout unless an output file is given, which is only colored if asked to.
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if *output != "" {
+		file, err = os.Create(*output)
+		checkErr(err)
+		out = file
+		if *colorMode != "always" {
+			color.NoColor = true
+		}
+	}
+
+	// Progress is updated in place, so it is only shown if stderr is a terminal. It is stopped once comparing is done.
+	start := time.Now()
+	stopProgress := func() {}
+	if *progress && !*quiet && isTerminal(os.Stderr) {
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		go showProgress(opts.Progress, done, stopped)
+		stopProgress = func() {
+			close(done)
+			<-stopped
+		}
+	}
+
+	// Differences are printed as they are found, except in brief mode where only whether there are any matters and in
+	// json mode which prints a single document. Standard input is compared up front.
+	// In serve mode differences are also streamed to HTTP clients as they are found, until interrupted.
+	var b *broadcaster
+	if *serve != "" {
+		ln, err := net.Listen("tcp", *serve)
+		checkErr(err)
+		b = newBroadcaster()
+		mux := http.NewServeMux()
+		mux.Handle("/events", b)
+		go http.Serve(ln, mux)
+		log.Printf("Streaming differences on http://%v/events", ln.Addr())
+	}
+
+	if *writeManifestTo != "" {
+		err = writeManifest(ctx, path2, path1, opts)
+		stopProgress()
+		checkErr(err)
 		os.Exit(0)
 	}
 
-	// Ensure path1 and path2 are either both files or both directories and act accordingly.
-	path1 := pflag.Args()[0]
-	path2 := pflag.Args()[1]
-	stat1, err := os.Stat(path1)
-	checkErr(err)
-	stat2, err := os.Stat(path2)
-	checkErr(err)
-	if !stat1.IsDir() && !stat2.IsDir() {
-		wg.Add(1)
-		go diffFiles(path1, path2)
-		wg.Wait()
-	} else if stat1.IsDir() && stat2.IsDir() {
-		wg.Add(1)
-		go diffDirs(path1, path2, *recursive)
-		wg.Wait()
+	// A printer is made for each comparison, which in watch mode happens whenever the paths change.
+	outputPrinter := func() *printer {
+		p := newPrinter(out)
+		p.json = *format == "json"
+		p.gnu = *format == "gnu"
+		p.print0 = *print0
+		p.patch = *patch
+		p.short = *shortFlag
+		p.syncPlan = *syncPlanFlag
+		p.sorted = *sorted
+		p.roots = []string{path1, path2, path3}
+		p.relative = *relativeFlag
+		p.noOnly = *noOnly
+		if *onlyInLeft {
+			p.onlyIn = 1
+		} else if *onlyInRight {
+			p.onlyIn = 2
+		}
+		p.silent = *brief || *quiet
+		p.verbose = *verbose && !*quiet
+		return p
+	}
+	if *watch {
+		checkErr(watchPaths(ctx, path1, path2, opts, file == nil && isTerminal(os.Stdout), outputPrinter))
+	}
+
+	var diffs <-chan compare.Difference
+	if *manifest != "" {
+		diffs, err = diffManifest(ctx, path1, path2, opts)
+	} else if *threeWay {
+		opts.Brief, opts.Stats = false, nil
+		diffs, err = diffThreeWay(ctx, path1, path2, path3, opts)
+	} else if (path1 == "-" || path2 == "-") && (compare.IsRemote(path1) || compare.IsRemote(path2)) {
+		log.Print("Cannot compare between standard input and a remote path.")
+		os.Exit(2)
+	} else if path1 == "-" && !stat2.IsDir() {
+		diffs, err = diffStdin(ctx, path2, false, opts)
+	} else if path2 == "-" && !stat1.IsDir() {
+		diffs, err = diffStdin(ctx, path1, true, opts)
+	} else if path1 == "-" || path2 == "-" {
+		log.Print("Cannot compare between standard input and a directory.")
+		os.Exit(2)
+	} else if stat1 != nil && stat2 != nil && stat1.IsDir() != stat2.IsDir() {
+		log.Print("Cannot compare between a file and a directory.")
+		os.Exit(2)
 	} else {
-		fmt.Println("Cannot compare between a file and a directory.")
+		diffs, err = compare.Diff(ctx, path1, path2, opts)
+	}
+	if err != nil {
+		stopProgress()
+		checkErr(err)
+	}
+
+	p := outputPrinter()
+
+	var planned, plannedSize int64
+	var failure string
+	var stopped bool
+	if *tui {
+		failure, stopped, err = browse(diffs, p.roots, opts.Progress, stop)
+		checkErr(err)
+	}
+	for d := range diffs {
+		if b != nil {
+			b.add(d)
+		}
+		if d.Type == compare.ERROR {
+			failure = d.Error
+			continue
+		}
+		if d.Type == compare.PLANNED {
+			planned++
+			plannedSize += *d.Size1 + *d.Size2
+		}
+		p.print(d)
+	}
+	stopProgress()
+	if b != nil {
+		b.finish()
+	}
+	if err := ctx.Err(); !errors.Is(err, context.Canceled) {
+		checkErr(err)
+	}
+	if failure != "" {
+		log.Print(failure)
+		os.Exit(2)
+	}
+	// Differences found anywhere in the comparison, even deep below the compared directories or dropped in brief mode,
+	// are aggregated in the result. Quitting the TUI early leaves the comparison incomplete.
+	differ, incomplete := opts.Result.Differ(), opts.Result.Incomplete() || stopped
+
+	if *brief && !*quiet && differ {
+		fmt.Fprintf(out, "Paths %v and %v %s\n", path1, path2, red("differ"))
+	}
+	checkErr(p.finish())
+
+	// Print the totals of the plan and the summary. They go to stderr in json and patch mode to keep the output a valid
+	// document or patch.
+	if *list && !*quiet {
+		summary := out
+		if *format == "json" || *patch {
+			summary = os.Stderr
+		}
+		fmt.Fprintf(summary, "%v files to compare, %v to read\n", planned, formatBytes(plannedSize))
+	}
+	if *stats && !*quiet {
+		summary := out
+		if *format == "json" || *patch {
+			summary = os.Stderr
+		}
+		s := opts.Stats
+		examined := s.Examined()
+		dirs := make([]string, 0, len(examined))
+		for dir := range examined {
+			dirs = append(dirs, dir)
+		}
+		sort.Strings(dirs)
+		for _, dir := range dirs {
+			e := examined[dir]
+			fmt.Fprintf(summary, "Examined %v files, %v directories in %v\n", e.Files, e.Dirs, filepath.Join(".", dir))
+		}
+		if len(examined) > 0 {
+			fmt.Fprintf(summary, "Examined %v files, %v directories in total\n", s.Files.Load(), s.Dirs.Load())
+		}
+		fmt.Fprintf(
+			summary, "%v files differ, %v only in %v, %v only in %v, %v type mismatches\n",
+			s.FilesDiffer.Load(), s.OnlyIn1.Load(), path1, s.OnlyIn2.Load(), path2, s.TypeMismatches.Load(),
+		)
+		fmt.Fprintln(summary, throughput(s.Bytes.Load(), time.Since(start)))
+	}
+	if *summaryJSON != "" {
+		checkErr(writeSummary(*summaryJSON, opts.Stats, opts.Progress, time.Since(start)))
+	}
+
+	if file != nil {
+		checkErr(file.Close())
+	}
+	if b != nil {
+		log.Print("Comparison done, still serving. Press Ctrl-C to stop.")
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+	}
+
+	// Follow the convention of exiting with status 1 if any differences were found, or 2 if some items could not be
+	// compared.
+	if incomplete {
+		os.Exit(2)
+	}
+	if differ {
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/samiksome92/diff/compare"
+)
+
+// broadcaster keeps the differences found so far and streams them to HTTP clients as server-sent events. Clients
+// connecting late first receive every difference found before they connected.
+type broadcaster struct {
+	mu    sync.Mutex
+	diffs []compare.Difference
+	done  bool
+	// Closed and replaced whenever a difference is added or the comparison is done, to wake up waiting clients.
+	changed chan struct{}
+}
+
+// newBroadcaster returns a broadcaster without any differences yet.
+func newBroadcaster() *broadcaster {
+	return &broadcaster{changed: make(chan struct{})}
+}
+
+// add adds a difference and sends it to all connected clients.
+func (b *broadcaster) add(d compare.Difference) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.diffs = append(b.diffs, d)
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// finish marks the comparison as done, after which clients are sent a done event once they have received every
+// difference.
+func (b *broadcaster) finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = true
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// ServeHTTP streams the differences to a client, each as a JSON encoded data event, followed by a done event once the
+// comparison is done.
+func (b *broadcaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	sent := 0
+	for {
+		b.mu.Lock()
+		diffs, done, changed := b.diffs[sent:], b.done, b.changed
+		b.mu.Unlock()
+
+		for _, d := range diffs {
+			data, err := json.Marshal(d)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		sent += len(diffs)
+		if done {
+			fmt.Fprint(w, "event: done\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
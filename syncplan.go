@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/samiksome92/diff/compare"
+)
+
+// Phases of a sync plan, in the order their actions are taken. Deleting comes first so that entries replaced by one of
+// another type are out of the way, and moves come before copies so that renamed files are not copied again.
+const (
+	PHASE_DELETE = iota
+	PHASE_MOVE
+	PHASE_COPY
+)
+
+// action is a step of a sync plan, in a phase and about a path relative to the compared directories.
+type action struct {
+	phase int
+	path  string
+	text  string
+}
+
+// syncPlan returns the actions which would make the second of two compared directories, at roots, match the first,
+// which is taken as the source of truth, given the differences found between them. Actions are in the order they are
+// to be taken: deletions with entries below a directory before the directory, then moves, then copies and updates with
+// directories before the entries below them. Several differences of the same path, such as in mode and time, make a
+// single action. Differences which call for no action, as well as items which could not be compared, are returned as
+// skipped.
+func syncPlan(diffs []compare.Difference, roots []string) (actions []string, skipped []compare.Difference) {
+	var plan []action
+	seen := make(map[string]bool)
+	add := func(phase int, path string, format string, args ...any) {
+		text := fmt.Sprintf(format, args...)
+		if !seen[text] {
+			seen[text] = true
+			plan = append(plan, action{phase, path, text})
+		}
+	}
+	for _, d := range diffs {
+		key := sortKey(d, roots)
+		switch d.Type {
+		case compare.ONLY_IN:
+			if d.Side == 1 {
+				add(PHASE_COPY, key, "copy %v", key)
+			} else {
+				add(PHASE_DELETE, key, "delete %v", key)
+			}
+		case compare.TYPE_MISMATCH:
+			add(PHASE_DELETE, key, "delete %v", key)
+			add(PHASE_COPY, key, "copy %v", key)
+		case compare.RENAMED:
+			to := relative(roots[1], d.Path2)
+			add(PHASE_MOVE, key, "move %v to %v", to, key)
+		case compare.EMPTY_DIR:
+			if d.Side == 1 {
+				add(PHASE_DELETE, key, "delete the contents of %v", key)
+			} else {
+				add(PHASE_COPY, key, "copy the contents of %v", key)
+			}
+		case compare.FILES_DIFFER, compare.MODE_DIFFER, compare.TIME_DIFFER, compare.OWNER_DIFFER,
+			compare.XATTR_DIFFER, compare.ATTRIBUTES_DIFFER, compare.LINKS_DIFFER, compare.DEVICES_DIFFER:
+			add(PHASE_COPY, key, "update %v", key)
+		case compare.IDENTICAL, compare.PLANNED, compare.TOO_SMALL:
+		default:
+			skipped = append(skipped, d)
+		}
+	}
+
+	sort.SliceStable(plan, func(i, j int) bool {
+		a, b := plan[i], plan[j]
+		switch {
+		case a.phase != b.phase:
+			return a.phase < b.phase
+		case a.phase == PHASE_DELETE:
+			return a.path > b.path
+		}
+		return a.path < b.path
+	})
+	actions = make([]string, len(plan))
+	for i, a := range plan {
+		actions[i] = a.text
+	}
+	return actions, skipped
+}
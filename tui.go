@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/samiksome92/diff/compare"
+)
+
+// browser is an interactive terminal UI listing differences in a tree by their paths relative to the compared paths,
+// with the details of the selected one, such as its unified diff or hex dump, shown beside it.
+type browser struct {
+	app    *tview.Application
+	tree   *tview.TreeView
+	detail *tview.TextView
+	status *tview.TextView
+	roots  []string
+	// Nodes of the tree by their relative path, "" being the root.
+	nodes map[string]*tview.TreeNode
+	// Number of differences added so far.
+	count int
+}
+
+// newBrowser returns a browser for the differences of paths compared at the given roots.
+func newBrowser(roots []string) *browser {
+	b := &browser{
+		app:    tview.NewApplication(),
+		detail: tview.NewTextView(),
+		status: tview.NewTextView(),
+		roots:  roots,
+		nodes:  make(map[string]*tview.TreeNode),
+	}
+	root := tview.NewTreeNode(fmt.Sprintf("%v vs %v", roots[0], roots[1])).SetColor(tcell.ColorWhite)
+	b.nodes[""] = root
+	b.tree = tview.NewTreeView().SetRoot(root).SetCurrentNode(root)
+	b.tree.SetBorder(true).SetTitle(" Differences ")
+	b.detail.SetWrap(false).SetBorder(true).SetTitle(" Details ")
+
+	// Moving through the tree shows the details of the current node, and selecting a directory expands or collapses it.
+	b.tree.SetChangedFunc(b.show)
+	b.tree.SetSelectedFunc(func(n *tview.TreeNode) {
+		if len(n.GetChildren()) > 0 {
+			n.SetExpanded(!n.IsExpanded())
+		}
+	})
+
+	// Tab switches between the tree and the details so that long diffs can be scrolled, and q or Esc quits.
+	b.app.SetInputCapture(func(e *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case e.Key() == tcell.KeyTab && b.tree.HasFocus():
+			b.app.SetFocus(b.detail)
+		case e.Key() == tcell.KeyTab:
+			b.app.SetFocus(b.tree)
+		case e.Key() == tcell.KeyEscape || e.Rune() == 'q':
+			b.app.Stop()
+		default:
+			return e
+		}
+		return nil
+	})
+
+	panes := tview.NewFlex().AddItem(b.tree, 0, 1, true).AddItem(b.detail, 0, 2, false)
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).AddItem(panes, 0, 1, true).AddItem(b.status, 1, 0, false)
+	b.app.SetRoot(layout, true)
+	return b
+}
+
+// add adds a difference to the tree under its relative path, creating the directories above it as needed. Several
+// differences of the same path, such as in mode and time, share a node.
+func (b *browser) add(d compare.Difference) {
+	b.count++
+	key := sortKey(d, b.roots)
+	if key == "." {
+		key = ""
+	}
+	n := b.node(key)
+	diffs, _ := n.GetReference().([]compare.Difference)
+	n.SetReference(append(diffs, d))
+
+	// Nodes are colored by their worst difference so far: red for differences, magenta for items which could not be
+	// compared and yellow for warnings.
+	switch {
+	case d.IsDifference():
+		n.SetColor(tcell.ColorRed)
+	case d.Incomplete() && n.GetColor() != tcell.ColorRed:
+		n.SetColor(tcell.ColorFuchsia)
+	case n.GetColor() == tcell.ColorWhite:
+		n.SetColor(tcell.ColorYellow)
+	}
+	if b.tree.GetCurrentNode() == n {
+		b.show(n)
+	}
+}
+
+// node returns the node of a relative path, creating it and the nodes of the directories above it if they do not
+// exist yet. New nodes are white until a difference is added to them.
+func (b *browser) node(key string) *tview.TreeNode {
+	if n, ok := b.nodes[key]; ok {
+		return n
+	}
+	parent, name := "", key
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		parent, name = key[:i], key[i+1:]
+	}
+	n := tview.NewTreeNode(name).SetColor(tcell.ColorWhite)
+	b.node(parent).AddChild(n)
+	b.nodes[key] = n
+	return n
+}
+
+// show shows the details of a node: the full text of its differences, or the number of differences below it for a
+// directory without any of its own.
+func (b *browser) show(n *tview.TreeNode) {
+	var lines []string
+	diffs, _ := n.GetReference().([]compare.Difference)
+	for _, d := range diffs {
+		if d.Type == compare.FILES_DIFFER && d.Diff != "" {
+			lines = append(lines, fmt.Sprintf("Files %v and %v differ", d.Path1, d.Path2), d.Diff)
+			continue
+		}
+		lines = append(lines, text(d))
+	}
+	if len(diffs) == 0 {
+		count := 0
+		n.Walk(func(node *tview.TreeNode, parent *tview.TreeNode) bool {
+			d, _ := node.GetReference().([]compare.Difference)
+			count += len(d)
+			return true
+		})
+		lines = append(lines, fmt.Sprintf("%v differences below", count))
+	}
+	b.detail.SetText(strings.Join(lines, "\n")).ScrollToBeginning()
+}
+
+// browse shows differences in the browser as they are received, along with the progress of the comparison, until the
+// user quits. It returns once diffs is closed, along with the error which stopped the comparison, if any. stop is
+// called if the user quits before the comparison is done so that diffs is closed early, in which case stopped is true.
+func browse(
+	diffs <-chan compare.Difference, roots []string, p *compare.Progress, stop func(),
+) (failure string, stopped bool, err error) {
+	b := newBrowser(roots)
+
+	// Differences are added in batches every PROGRESS_INTERVAL, so that finding many at once does not overwhelm the
+	// UI. Updates stop once the UI has stopped, as they would never be applied.
+	var mu sync.Mutex
+	var pending []compare.Difference
+	done, quit := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for d := range diffs {
+			if d.Type == compare.ERROR {
+				failure = d.Error
+				continue
+			}
+			mu.Lock()
+			pending = append(pending, d)
+			mu.Unlock()
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(PROGRESS_INTERVAL)
+		defer ticker.Stop()
+		for finished := false; !finished; {
+			select {
+			case <-quit:
+				return
+			case <-done:
+				finished = true
+			case <-ticker.C:
+			}
+			mu.Lock()
+			batch := pending
+			pending = nil
+			mu.Unlock()
+			state := "Comparing"
+			if finished {
+				state = "Done"
+			}
+			b.app.QueueUpdateDraw(func() {
+				for _, d := range batch {
+					b.add(d)
+				}
+				// Directories show the number of differences below them, which may have grown.
+				if n := b.tree.GetCurrentNode(); n != nil && n.GetReference() == nil {
+					b.show(n)
+				}
+				b.status.SetText(fmt.Sprintf(
+					" %v: %v files compared, %v read, %v items. Tab switches panes, q quits.", state, p.Files.Load(),
+					formatBytes(p.Bytes.Load()), b.count,
+				))
+			})
+		}
+	}()
+
+	err = b.app.Run()
+	close(quit)
+	select {
+	case <-done:
+	default:
+		stop()
+		<-done
+		stopped = true
+	}
+	return failure, stopped, err
+}
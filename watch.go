@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/samiksome92/diff/compare"
+)
+
+// How long to wait for more changes after a change to the watched paths before comparing them again, so that a burst
+// of writes only causes a single comparison.
+const WATCH_DELAY = 300 * time.Millisecond
+
+// watcher watches two paths for changes, along with every directory below them. Files are watched through their
+// directories, since editors often replace a file instead of writing to it.
+type watcher struct {
+	*fsnotify.Watcher
+	// Watched files by their directories. Changes to other entries of those directories are ignored.
+	files map[string]map[string]bool
+	// Whether changes to permission bits and attributes count, which they only do when comparing them.
+	chmod bool
+}
+
+// newWatcher returns a watcher watching the given paths.
+func newWatcher(chmod bool, paths ...string) (*watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{Watcher: fw, files: make(map[string]map[string]bool), chmod: chmod}
+	for _, p := range paths {
+		stat, err := os.Stat(p)
+		if err == nil && !stat.IsDir() {
+			p = filepath.Clean(p)
+			if w.files[filepath.Dir(p)] == nil {
+				w.files[filepath.Dir(p)] = make(map[string]bool)
+			}
+			w.files[filepath.Dir(p)][p] = true
+			err = w.Add(filepath.Dir(p))
+		} else if err == nil {
+			err = w.addTree(p)
+		}
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// addTree watches a directory and every directory below it. Directories which cannot be read are left out, as
+// comparing reports them.
+func (w *watcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(p string, e fs.DirEntry, err error) error {
+		if err != nil && p == dir {
+			return err
+		}
+		if err != nil || !e.IsDir() {
+			return nil
+		}
+		return w.Add(p)
+	})
+}
+
+// wait blocks until the watched paths change and then stay unchanged for WATCH_DELAY, or until ctx is done in which
+// case its error is returned. Directories created in the meantime are watched as well.
+func (w *watcher) wait(ctx context.Context) error {
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-settled:
+			return nil
+		case err := <-w.Errors:
+			// Events may have been lost, so the paths are compared again to be safe.
+			log.Print(err)
+			settled = time.After(WATCH_DELAY)
+		case e := <-w.Events:
+			if files, ok := w.files[filepath.Dir(e.Name)]; ok && !files[e.Name] {
+				continue
+			}
+			if e.Op == fsnotify.Chmod && !w.chmod {
+				continue
+			}
+			if stat, err := os.Stat(e.Name); err == nil && stat.IsDir() && e.Has(fsnotify.Create) {
+				if err := w.addTree(e.Name); err != nil {
+					log.Print(err)
+				}
+			}
+			settled = time.After(WATCH_DELAY)
+		}
+	}
+}
+
+// watchPaths compares two local paths, and then again whenever either of them changes until ctx is done, printing the
+// differences found each time with a printer returned by newPrinter. If clear is set the screen is cleared before each
+// comparison. Errors stopping a single comparison are logged, and the paths are compared again once they change.
+func watchPaths(
+	ctx context.Context, path1 string, path2 string, opts compare.Options, clear bool, newPrinter func() *printer,
+) error {
+	w, err := newWatcher(opts.Mode || opts.Attributes, path1, path2)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	for {
+		if clear {
+			fmt.Print("\x1b[H\x1b[2J")
+		}
+		log.Printf("Comparing at %v, watching for changes.", time.Now().Format(time.TimeOnly))
+		diffs, err := compare.Diff(ctx, path1, path2, opts)
+		if err != nil {
+			log.Print(err)
+		} else {
+			p := newPrinter()
+			for d := range diffs {
+				if d.Type == compare.ERROR {
+					log.Print(d.Error)
+					continue
+				}
+				p.print(d)
+			}
+			if err := p.finish(); err != nil {
+				return err
+			}
+		}
+		if err := w.wait(ctx); err != nil {
+			return err
+		}
+	}
+}
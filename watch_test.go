@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWatchFilesInSameDir checks that changes to either of two watched files in the same directory are noticed, while
+// changes to other files of the directory are not.
+func TestWatchFilesInSameDir(t *testing.T) {
+	dir := t.TempDir()
+	file1, file2, other := filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "c")
+	for _, file := range []string{file1, file2, other} {
+		if err := os.WriteFile(file, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := newWatcher(false, file1, file2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, test := range []struct {
+		file    string
+		changed bool
+	}{{other, false}, {file1, true}, {file2, true}} {
+		if err := os.WriteFile(test.file, []byte("y"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err := w.wait(ctx)
+		cancel()
+		if changed := err == nil; changed != test.changed {
+			t.Errorf("changing %v: got changed %v, want %v", filepath.Base(test.file), changed, test.changed)
+		}
+	}
+}